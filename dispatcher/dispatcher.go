@@ -7,7 +7,10 @@ import (
 	"listenbrainz-daily-playlist/listenbrainz"
 	"listenbrainz-daily-playlist/retry"
 	"listenbrainz-daily-playlist/subsonic"
+	"maps"
 	"net/url"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,22 +23,47 @@ import (
 const (
 	taskTimeMs = 30_000
 	queueName  = "job-queue"
+
+	// Defaults of the global settings, as in the manifest
+	defaultFallbackCount   = 15
+	defaultMatchCacheHours = 24
+	defaultLedgerRetention = 14
 )
 
 // Dispatches a job.
 // The first return value denotes an unrecoverable error (do not retry)
 // The second return value is an error that should be reattempted
 func (j *Job) Dispatch() *retry.Error {
+	start := time.Now()
+	var err *retry.Error
+
 	switch j.JobType {
 	case FetchPatches:
-		return j.dispatchSourceFetching()
+		err = j.dispatchSourceFetching()
 	case GenerateJams:
-		return j.dispatchGenerate()
+		err = j.dispatchGenerate()
 	case ImportPlaylist:
-		return j.dispatchImport()
+		err = j.dispatchImport()
+	case SyncFeedback:
+		err = j.dispatchFeedbackSync()
+	case TopTracks:
+		err = j.dispatchTopTracks()
+	case FreshReleases:
+		err = j.dispatchFreshReleases()
+	case Radio:
+		err = j.dispatchRadio()
+	case Mirror:
+		err = j.dispatchMirror()
+	case DiscoverPatches:
+		err = j.dispatchDiscoverPatches()
+	case Rematch:
+		err = j.dispatchRematch()
 	default:
 		return retry.FatalError(fmt.Sprintf("unexpected job %s", j.JobType))
 	}
+
+	j.record(start, err)
+	return err
 }
 
 func (j *Job) dispatchSourceFetching() *retry.Error {
@@ -52,46 +80,60 @@ func (j *Job) dispatchSourceFetching() *retry.Error {
 	}
 
 	var ignoredError error = nil
+	existing := lazyPlaylists(j.Username)
 
 	for _, source := range j.Patch.Sources {
-		playlistId := ""
-
-		for _, plsMetadata := range playlists {
-			if plsMetadata.Extension.Extension.AdditionalMetadata.AlgorithmMetadata.SourcePatch == source.SourcePatch {
-				playlistId = listenbrainz.GetIdentifier(plsMetadata.Identifier)
-				break
-			}
-		}
+		groups := sourcePlaylists(playlists, source.SourcePatch)
 
-		if playlistId == "" {
+		if len(groups) == 0 {
 			newErr := fmt.Errorf("no playlist for ListenBrainz user `%s` found with algorithm/source patch `%s`", j.LbzUsername, source.SourcePatch)
 			ignoredError = errors.Join(ignoredError, newErr)
+			j.stats.setError(source.PlaylistName, newErr)
 			pdk.Log(pdk.LogError, newErr.Error())
 			continue
 		}
 
-		newJob := Job{
-			JobType:     ImportPlaylist,
-			Username:    j.Username,
-			LbzUsername: j.LbzUsername,
-			LbzToken:    j.LbzToken,
-			Ratings:     j.Ratings,
-			Import: &importJob{
-				Name:  source.PlaylistName,
-				LbzId: playlistId,
-			},
-		}
+		imported := []string{}
 
-		payload, serializeErr := json.Marshal(newJob)
-		if serializeErr != nil {
-			pdk.Log(pdk.LogError, fmt.Sprintf("Error serializing import job: %v", serializeErr))
-			ignoredError = errors.Join(ignoredError, serializeErr)
-			continue
+		for _, patch := range slices.Sorted(maps.Keys(groups)) {
+			editions := groups[patch]
+			name := sourceName(source.PlaylistName, patch, editions[0])
+
+			// The newest playlist is imported under the source name, and the previous editions under their own dated names
+			imports := map[string]*listenbrainz.LbzPlaylist{name: editions[0]}
+			names := []string{name}
+
+			for _, edition := range editions[1:] {
+				if len(names) > source.History {
+					break
+				}
+
+				dated := editionName(name, patch, edition)
+				if _, ok := imports[dated]; !ok {
+					imports[dated] = edition
+					names = append(names, dated)
+				}
+			}
+
+			for _, importName := range names {
+				err := j.queueImport(importName, imports[importName], existing)
+				if err != nil {
+					if err.Retryable {
+						return err
+					}
+					ignoredError = errors.Join(ignoredError, err.Error)
+				}
+			}
+
+			imported = append(imported, names...)
 		}
 
-		_, taskErr := host.TaskEnqueue(queueName, payload)
-		if taskErr != nil {
-			return retry.TempError(taskErr)
+		err := j.pruneEditions(source, imported, existing)
+		if err != nil {
+			if err.Retryable {
+				return err
+			}
+			ignoredError = errors.Join(ignoredError, err.Error)
 		}
 	}
 
@@ -103,153 +145,78 @@ func (j *Job) dispatchSourceFetching() *retry.Error {
 }
 
 func (j *Job) dispatchGenerate() *retry.Error {
-	if j.Generate == nil {
+	if len(j.Generated) == 0 {
 		return retry.FatalError("attempting to call generate job without generate payload")
 	}
 
-	pdk.Log(pdk.LogInfo, fmt.Sprintf("Generating playlist `%s` for user %s", j.Generate.Name, j.Username))
+	names := make([]string, len(j.Generated))
+	for idx, generate := range j.Generated {
+		names[idx] = "`" + generate.Name + "`"
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Generating playlist(s) %s for user %s", strings.Join(names, ", "), j.Username))
 
 	now := time.Now()
 
-	recommendations, err := listenbrainz.GetRecommendations(j.LbzUsername, j.LbzToken)
+	feedback, err := j.loadFeedback()
 	if err != nil {
-		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch recommendations for user %s: %v", j.Username, err.Error))
 		return err
 	}
 
-	mbids := make([]string, len(recommendations.Payload.MBIDs))
-	for idx, recording := range recommendations.Payload.MBIDs {
-		mbids[idx] = recording.RecordingMBID
-	}
-
-	metadata, err := listenbrainz.LookupRecordings(mbids, j.LbzToken)
+	listened, err := j.loadRecentListens(now)
 	if err != nil {
-		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to lookup %d recordings for user %s: %v", len(mbids), j.Username, err.Error))
 		return err
 	}
 
-	allowedSongs := []*types.Track{}
-	notPlayed := []*types.Track{}
-
-	tracks := make([]types.SongRef, len(mbids))
-
-	for idx, mbid := range mbids {
-		recordingMetadata, ok := metadata[mbid]
-		if !ok {
-			pdk.Log(pdk.LogWarn, fmt.Sprintf("Warning: track with mbid %s not found in metadata lookup. Skipping", mbid))
-			continue
-		}
+	// Each source is fetched and matched at most once, and shared by every playlist using it
+	pools := map[string]*recommendationPool{}
+	poolErrors := map[string]*retry.Error{}
 
-		tracks[idx].Album = recordingMetadata.Release.Name
-		tracks[idx].AlbumMBID = recordingMetadata.Release.MBID
-		tracks[idx].DurationMs = recordingMetadata.Recording.Length
-		tracks[idx].Name = recordingMetadata.Recording.Name
-		tracks[idx].MBID = mbid
-
-		if len(recordingMetadata.Recording.ISRCs) > 0 {
-			tracks[idx].ISRC = recordingMetadata.Recording.ISRCs[0]
-		}
+	var ignoredError error = nil
 
-		tracks[idx].Artists = make([]types.ArtistRef, len(recordingMetadata.Artist.Artists))
+	for idx := range j.Generated {
+		generate := &j.Generated[idx]
 
-		for artistIdx, artist := range recordingMetadata.Artist.Artists {
-			tracks[idx].Artists[artistIdx].Name = artist.Name
-			tracks[idx].Artists[artistIdx].MBID = artist.ArtistMbid
+		source := generate.Source
+		if source == "" {
+			source = sourceRecommendations
 		}
-	}
-
-	matches, matchErr := host.MatcherMatchSongs(tracks, host.MatchOptions{Username: j.Username})
-
-	if matchErr != nil {
-		return &retry.Error{Error: matchErr, Retryable: false}
-	}
-
-	missing := []string{}
-	excluded := []string{}
-	recentCount := 0
-
-	for idx, song := range matches {
-		if song != nil {
-			if !j.Ratings[song.Rating] {
-				excluded = append(excluded, song.Title)
-				continue
-			}
-
-			if song.PlayDate == nil {
-				notPlayed = append(notPlayed, song)
-				continue
-			}
-
-			playTime := time.Unix(*song.PlayDate, 0)
 
-			if now.Sub(playTime).Hours() < float64(j.Generate.TrackAge*24) {
-				recentCount += 1
-				pdk.Log(pdk.LogTrace, fmt.Sprintf("Excluding track `%s` for being played recently", song.Title))
-				continue
+		pool, ok := pools[source]
+		if !ok {
+			pool, err = j.loadPool(source, now)
+			if err == nil {
+				pool.feedback = feedback
+				pool.listened = listened
 			}
 
-			allowedSongs = append(allowedSongs, song)
-		} else {
-			missing = append(missing, tracks[idx].Name)
+			pools[source] = pool
+			poolErrors[source] = err
 		}
-	}
-
-	if len(allowedSongs) < 50 {
-		unlistenedCount := min(50-len(allowedSongs), len(notPlayed))
-		allowedSongs = append(allowedSongs, notPlayed[0:unlistenedCount]...)
-	}
-
-	songIds := []string{}
 
-	if j.Generate.ArtistLimit == 0 {
-		for _, song := range allowedSongs[:min(len(allowedSongs), 50)] {
-			songIds = append(songIds, song.ID)
+		err = poolErrors[source]
+		if err == nil {
+			err = j.generatePlaylist(generate, pool)
 		}
-	} else {
-		artistCredits := map[string]int{}
-
-	outer:
-		for _, song := range allowedSongs {
-			for _, artist := range song.Participants {
-				if artist.Role == "artist" {
-					count := artistCredits[artist.ID]
-					if count >= j.Generate.ArtistLimit {
-						continue outer
-					}
-				}
-			}
 
-			songIds = append(songIds, song.ID)
-			if len(songIds) == 50 {
-				break outer
+		if err != nil {
+			pdk.Log(pdk.LogError, fmt.Sprintf("Unable to import playlist `%s` for user %s: %v", generate.Name, j.Username, err.Error))
+			if err.Retryable {
+				return err
 			}
 
-			for _, artist := range song.Participants {
-				if artist.Role == "artist" {
-					artistCredits[artist.ID] += 1
-				}
-			}
+			ignoredError = errors.Join(ignoredError, err.Error)
+			j.stats.setError(generate.Name, err.Error)
+			continue
 		}
-	}
-
-	recsUpdated := time.Unix(recommendations.Payload.LastUpdated, recommendations.Payload.LastUpdated).Format(time.RFC1123)
 
-	comment := fmt.Sprintf(
-		"Jams generated on %s with %d recommendations generated on %s."+
-			"\nExcluded by rating rules: %s\nTracks not found in library: %s\nExcluded for being recent: %d",
-		now.Format(time.RFC1123), len(mbids), recsUpdated,
-		strings.Join(excluded, ", "),
-		strings.Join(missing, ", "),
-		recentCount,
-	)
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Successfully generated playlist `%s` for user %s", generate.Name, j.Username))
+	}
 
-	err = subsonic.UpdatePlaylist(j.Username, j.Generate.Name, comment, songIds)
-	if err != nil {
-		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to import playlist `%s` for user %s: %v", j.Generate.Name, j.Username, err.Error))
-		return err
+	if ignoredError != nil {
+		return &retry.Error{Error: ignoredError, Retryable: false}
 	}
 
-	pdk.Log(pdk.LogInfo, fmt.Sprintf("Successfully generated playlist `%s` for user %s", j.Generate.Name, j.Username))
 	return nil
 }
 
@@ -259,6 +226,22 @@ func (j *Job) dispatchImport() *retry.Error {
 	}
 
 	pdk.Log(pdk.LogInfo, fmt.Sprintf("Importing playlist `%s` (%s)", j.Import.Name, j.Import.LbzId))
+	j.stats.setLbzId(j.Import.Name, j.Import.LbzId)
+
+	// A playlist imported before is only fetched in full once its date changed, which is much cheaper to check
+	if state := loadPlaylistState(j.Username, j.Import.Name); !j.DryRun && state != nil && state.LbzId == j.Import.LbzId {
+		updated, err := listenbrainz.GetPlaylistUpdated(j.Import.LbzId, j.LbzToken)
+		if err != nil {
+			pdk.Log(pdk.LogError, fmt.Sprintf("Unable to check playlist %s for changes: %v", j.Import.LbzId, err.Error))
+			return err
+		}
+
+		if isUpToDate(j.Username, j.Import.Name, j.Import.LbzId, updated, lazyPlaylists(j.Username)) {
+			pdk.Log(pdk.LogInfo, fmt.Sprintf("Playlist `%s` for user %s is up to date with ListenBrainz playlist %s, skipping", j.Import.Name, j.Username, j.Import.LbzId))
+			j.stats.setSkipped(j.Import.Name, "ListenBrainz playlist unchanged since last import")
+			return nil
+		}
+	}
 
 	playlist, err := listenbrainz.GetPlaylist(j.Import.LbzId, j.LbzToken)
 	if err != nil {
@@ -266,7 +249,28 @@ func (j *Job) dispatchImport() *retry.Error {
 		return err
 	}
 
+	updated := playlist.Updated()
+
+	header := fmt.Sprintf("Imported from playlist %s\nUpdated on: %s", playlist.Identifier, playlist.Date.Format(time.RFC3339))
+
+	written, err := j.writeLbzPlaylist(j.Import.Name, header, playlist)
+	if err != nil {
+		return err
+	}
+
+	if written && !j.DryRun {
+		savePlaylistState(j.Username, j.Import.Name, playlistState{LbzId: j.Import.LbzId, Updated: updated})
+	}
+
+	return nil
+}
+
+// Matches the tracks of a ListenBrainz playlist against the library and writes them to the playlist name.
+// The comment starts with header, followed by the tracks that were not matched or excluded.
+// Returns whether the playlist was written
+func (j *Job) writeLbzPlaylist(name, header string, playlist *listenbrainz.LbzPlaylist) (bool, *retry.Error) {
 	tracks := make([]types.SongRef, len(playlist.Tracks))
+	creators := make([]string, len(playlist.Tracks))
 
 	for idx, track := range playlist.Tracks {
 		mbid := listenbrainz.GetIdentifier(track.Identifier[0])
@@ -274,6 +278,7 @@ func (j *Job) dispatchImport() *retry.Error {
 		tracks[idx].DurationMs = track.Duration
 		tracks[idx].Name = track.Title
 		tracks[idx].MBID = mbid
+		creators[idx] = track.Creator
 
 		tracks[idx].Artists = make([]types.ArtistRef, len(track.Extension.Track.AdditionalMetadata.Artists))
 
@@ -283,54 +288,13 @@ func (j *Job) dispatchImport() *retry.Error {
 		}
 	}
 
-	matches, matchErr := host.MatcherMatchSongs(tracks, host.MatchOptions{Username: j.Username})
-
-	if matchErr != nil {
-		return &retry.Error{Error: matchErr, Retryable: false}
-	}
-
-	songIds := []string{}
-	missing := []string{}
-	excluded := []string{}
-
-	for idx, song := range matches {
-		if song != nil {
-			if j.Ratings[song.Rating] {
-				songIds = append(songIds, song.ID)
-			} else {
-				excluded = append(excluded, fmt.Sprintf("%s by %s", song.Title, song.Artist))
-			}
-		} else {
-			missing = append(missing, fmt.Sprintf("%s by %s", tracks[idx].Name, playlist.Tracks[idx].Creator))
-		}
-	}
-
-	name := j.Import.Name
-
-	if len(songIds) == 0 {
-		pdk.Log(pdk.LogWarn, fmt.Sprintf("No matching files found for playlist %s. Refusing to create/update", name))
-		return nil
-	}
-
-	comment := fmt.Sprintf("Imported from playlist %s\nUpdated on: %s", playlist.Identifier, playlist.Date.Format(time.RFC3339))
-
-	if len(missing) > 0 {
-		comment += "\nTracks not matched " + strings.Join(missing, ", ")
-	}
-
-	if len(excluded) > 0 {
-		comment += "\nTracks excluded by rating rule: " + strings.Join(excluded, ", ")
-	}
-
-	err = subsonic.UpdatePlaylist(j.Username, name, comment, songIds)
-
-	if err != nil {
-		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to import playlist `%s` for user %s: %v", name, j.Username, err.Error))
-		return err
+	written, err := j.writeMatchedPlaylist(name, header, tracks, creators)
+	if err != nil || !written {
+		return false, err
 	}
 
 	pdk.Log(pdk.LogInfo, fmt.Sprintf("Successfully processed playlist `%s` for user %s", name, j.Username))
-	return nil
+	return true, nil
 }
 
 func GetConfig() ([]userConfig, error) {
@@ -360,16 +324,155 @@ func GetConfig() ([]userConfig, error) {
 				}
 
 				names[source.PlaylistName] = true
+
+				if _, err := path.Match(source.SourcePatch, ""); err != nil {
+					return nil, fmt.Errorf("source patch of playlist %s is not a valid pattern: %s", source.PlaylistName, source.SourcePatch)
+				}
+
+				if isPatchPattern(source.SourcePatch) && !strings.Contains(source.PlaylistName, "{title}") && !strings.Contains(source.PlaylistName, "{patch}") {
+					return nil, fmt.Errorf("playlist name of source %s must contain {title} or {patch}, as it can match several source patches: %s", source.SourcePatch, source.PlaylistName)
+				}
+
+				if source.History < 0 {
+					return nil, fmt.Errorf("history of playlist %s cannot be negative: %d", source.PlaylistName, source.History)
+				}
+
+				err = validateSchedule(source.PlaylistName, source.Schedule)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 
-		if user.GeneratePlaylist && user.GeneratedPlaylist != "" {
-			_, existing := names[user.GeneratedPlaylist]
+		for _, generated := range user.generatedPlaylists() {
+			if generated.Name == "" {
+				return nil, errors.New("generated playlist must have a name")
+			}
+
+			_, existing := names[generated.Name]
 			if existing {
-				return nil, fmt.Errorf("duplicate playlist name found: %s", user.GeneratedPlaylist)
+				return nil, fmt.Errorf("duplicate playlist name found: %s", generated.Name)
+			}
+
+			names[generated.Name] = true
+
+			if generated.UnplayedRatio < 0 || generated.UnplayedRatio > 100 {
+				return nil, fmt.Errorf("unplayed ratio of playlist %s must be between [0, 100], inclusive: %d", generated.Name, generated.UnplayedRatio)
 			}
 
-			names[user.GeneratedPlaylist] = true
+			if generated.RepeatMode != "" && generated.RepeatMode != repeatExclude && generated.RepeatMode != repeatDownweight {
+				return nil, fmt.Errorf("repeat mode of playlist %s must be `%s` or `%s`: %s", generated.Name, repeatExclude, repeatDownweight, generated.RepeatMode)
+			}
+
+			if generated.Source != "" && generated.Source != sourceRecommendations && generated.Source != sourceForgotten {
+				return nil, fmt.Errorf("source of playlist %s must be `%s` or `%s`: %s", generated.Name, sourceRecommendations, sourceForgotten, generated.Source)
+			}
+
+			if generated.Source == sourceForgotten && generated.TrackAge <= 0 {
+				return nil, fmt.Errorf("forgotten favorites playlist %s must exclude tracks played in the last X days", generated.Name)
+			}
+
+			err = validateSchedule(generated.Name, generated.Schedule)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, top := range user.TopPlaylists {
+			_, existing := names[top.Name]
+			if existing {
+				return nil, fmt.Errorf("duplicate playlist name found: %s", top.Name)
+			}
+			names[top.Name] = true
+
+			if !slices.Contains(statsRanges, top.Range) {
+				return nil, fmt.Errorf("range of playlist %s must be one of %s: %s", top.Name, strings.Join(statsRanges, ", "), top.Range)
+			}
+
+			err = validateSchedule(top.Name, top.Schedule)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if user.FreshReleasesPlaylist != "" {
+			_, existing := names[user.FreshReleasesPlaylist]
+			if existing {
+				return nil, fmt.Errorf("duplicate playlist name found: %s", user.FreshReleasesPlaylist)
+			}
+			names[user.FreshReleasesPlaylist] = true
+
+			if user.FreshReleasesPast < 0 || user.FreshReleasesFuture < 0 {
+				return nil, fmt.Errorf("fresh releases window of playlist %s cannot be negative", user.FreshReleasesPlaylist)
+			}
+
+			err = validateSchedule(user.FreshReleasesPlaylist, user.FreshReleasesSchedule)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, radio := range user.RadioPlaylists {
+			_, existing := names[radio.Name]
+			if existing {
+				return nil, fmt.Errorf("duplicate playlist name found: %s", radio.Name)
+			}
+			names[radio.Name] = true
+
+			if strings.TrimSpace(radio.Prompt) == "" {
+				return nil, fmt.Errorf("radio playlist %s must have a prompt", radio.Name)
+			}
+
+			if !slices.Contains(radioModes, radio.Mode) {
+				return nil, fmt.Errorf("mode of radio playlist %s must be one of %s: %s", radio.Name, strings.Join(radioModes, ", "), radio.Mode)
+			}
+
+			if user.LbzToken == "" {
+				return nil, fmt.Errorf("radio playlist %s requires a ListenBrainz token", radio.Name)
+			}
+
+			err = validateSchedule(radio.Name, radio.Schedule)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if user.MirrorNameTemplate != "" && !strings.Contains(user.MirrorNameTemplate, "{title}") {
+			return nil, fmt.Errorf("mirrored playlist name template of user %s must contain {title}: %s", user.NDUsername, user.MirrorNameTemplate)
+		}
+
+		if user.SyncFeedback && user.LbzToken == "" {
+			return nil, fmt.Errorf("feedback sync for user %s requires a ListenBrainz token", user.NDUsername)
+		}
+
+		if user.FeedbackHateRating < 0 || user.FeedbackHateRating > 4 {
+			return nil, fmt.Errorf("hated rating of user %s must be between [0, 4], inclusive: %d", user.NDUsername, user.FeedbackHateRating)
+		}
+
+		for _, preference := range user.PreferVersions {
+			if !slices.Contains(versionPreferences, preference) {
+				return nil, fmt.Errorf("version preference of user %s must be one of %s: %s", user.NDUsername, strings.Join(versionPreferences, ", "), preference)
+			}
+		}
+
+		if slices.Contains(user.PreferVersions, preferLibrary) && user.PreferredLibrary <= 0 {
+			return nil, fmt.Errorf("preferring a library for user %s requires a preferred library ID", user.NDUsername)
+		}
+
+		overridden := map[string]bool{}
+		for _, override := range user.MatchOverrides {
+			if override.MBID == "" {
+				return nil, fmt.Errorf("match overrides of user %s must have a recording MBID", user.NDUsername)
+			}
+
+			if overridden[override.MBID] {
+				return nil, fmt.Errorf("duplicate match override of user %s for recording %s", user.NDUsername, override.MBID)
+			}
+			overridden[override.MBID] = true
+
+			if override.Never == (override.SongID != "") {
+				return nil, fmt.Errorf("match override of user %s for recording %s must either have a song ID or never match", user.NDUsername, override.MBID)
+			}
 		}
 
 		if len(user.Playlists) > 0 {
@@ -379,6 +482,11 @@ func GetConfig() ([]userConfig, error) {
 					return nil, fmt.Errorf("duplicate playlist name found: %s", playlist.Name)
 				}
 				names[playlist.Name] = true
+
+				err = validateSchedule(playlist.Name, playlist.Schedule)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -407,19 +515,95 @@ func parseRatings(ratingString []string) map[int32]bool {
 	return ratings
 }
 
+// Returns a non-negative integer config value, or defaultValue, matching the manifest default, if it is not set or invalid
+func getIntConfig(key string, defaultValue int) int {
+	value, ok := pdk.GetConfig(key)
+	if !ok || value == "" {
+		return defaultValue
+	}
+
+	intValue, err := strconv.Atoi(value)
+	if err != nil || intValue < 0 {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Invalid value for %s `%s`, using the default of %d", key, value, defaultValue))
+		return defaultValue
+	}
+
+	return intValue
+}
+
+// The job of jobType for a user, with the settings of the user and of the plugin used by jobs.
+// Every job for a user is built here, so that all job types get the same settings
+func userJob(user userConfig, jobType JobType) Job {
+	dryRun, _ := pdk.GetConfig("dryRun")
+	matchReports, _ := pdk.GetConfig("matchReports")
+	rematchMissing, _ := pdk.GetConfig("rematchMissing")
+
+	return Job{
+		JobType:           jobType,
+		Username:          user.NDUsername,
+		LbzUsername:       user.LbzUsername,
+		LbzToken:          user.LbzToken,
+		Ratings:           parseRatings(user.Ratings),
+		FallbackCount:     getIntConfig("fallbackCount", defaultFallbackCount),
+		MatchCacheHours:   getIntConfig("matchCacheHours", defaultMatchCacheHours),
+		Overrides:         user.overrides(),
+		Preference:        newVersionPreference(user.PreferVersions, user.PreferredLibrary),
+		MatchReports:      matchReports == "true",
+		RematchMissing:    rematchMissing == "true",
+		LedgerRetention:   getIntConfig("ledgerRetention", defaultLedgerRetention),
+		DryRun:            dryRun == "true",
+		ExcludeHated:      user.ExcludeHated,
+		BoostLoved:        user.BoostLoved,
+		RecentListenLimit: user.RecentListenLimit,
+	}
+}
+
+// Syncs every configured playlist, regardless of its schedule
 func InitialFetch() error {
+	return fetchPlaylists(func(_, _, _ string) bool {
+		return true
+	})
+}
+
+// Queues jobs for all missing or outdated playlists for which include returns true
+func fetchPlaylists(include func(username, playlist, schedule string) bool) error {
 	users, err := GetConfig()
 	if err != nil {
 		return err
 	}
 
 	nowTs := time.Now()
+	ledgerRetention := getIntConfig("ledgerRetention", defaultLedgerRetention)
 
 	missing := []string{}
-	olderThanThreeHours := []string{}
+	// Playlists rebuilt from the library more than three hours ago
+	outdated := []string{}
+	// Playlists only refreshed if changed on ListenBrainz, which is checked when fetching
+	checked := []string{}
 
 	jobs := []Job{}
 
+	// Playlists built from the library as much as from ListenBrainz are rebuilt when missing or outdated
+	needsRebuild := func(username, name string, playlistResp *subsonic.JsonWrapper) bool {
+		label := fmt.Sprintf("User: `%s`, Source: `%s`", username, name)
+		pls := subsonic.FindExistingPlaylist(playlistResp, name)
+
+		if pls == nil {
+			missing = append(missing, label)
+			recordDecision(username, name, statusQueued, "playlist missing", ledgerRetention)
+			return true
+		}
+
+		if nowTs.Sub(pls.Changed) > 3*time.Hour {
+			outdated = append(outdated, label)
+			recordDecision(username, name, statusQueued, "playlist outdated", ledgerRetention)
+			return true
+		}
+
+		recordDecision(username, name, statusSkipped, "playlist up to date", ledgerRetention)
+		return false
+	}
+
 	for _, user := range users {
 		playlistResp, err := subsonic.Call("getPlaylists", user.NDUsername, &url.Values{"username": []string{user.NDUsername}})
 		if err != nil {
@@ -427,101 +611,191 @@ func InitialFetch() error {
 		}
 
 		fetchedSources := []source{}
-		rating := parseRatings(user.Ratings)
 
 		if len(user.Sources) > 0 {
 			for _, source := range user.Sources {
+				if !include(user.NDUsername, source.PlaylistName, source.Schedule) {
+					continue
+				}
+
 				pls := subsonic.FindExistingPlaylist(playlistResp, source.PlaylistName)
 
 				if pls == nil {
 					missing = append(missing, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, source.PlaylistName))
 					fetchedSources = append(fetchedSources, source)
+					recordDecision(user.NDUsername, source.PlaylistName, statusQueued, "playlist missing", ledgerRetention)
 					continue
 				}
 
-				if nowTs.Sub(pls.Changed) > 3*time.Hour {
-					olderThanThreeHours = append(olderThanThreeHours, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, source.PlaylistName))
-					fetchedSources = append(fetchedSources, source)
-				}
+				// Whether the source actually changed is decided when fetching, using the ListenBrainz playlist date
+				checked = append(checked, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, source.PlaylistName))
+				fetchedSources = append(fetchedSources, source)
+				recordDecision(user.NDUsername, source.PlaylistName, statusQueued, "checking for upstream changes", ledgerRetention)
 			}
 		}
 
 		if len(fetchedSources) > 0 {
-			jobs = append(jobs, Job{
-				JobType:     FetchPatches,
-				Username:    user.NDUsername,
-				LbzUsername: user.LbzUsername,
-				LbzToken:    user.LbzToken,
-				Ratings:     rating,
-				Patch: &patchJob{
-					Sources: fetchedSources,
-				},
-			})
-		}
-
-		if user.GeneratePlaylist && user.GeneratedPlaylist != "" {
-			pls := subsonic.FindExistingPlaylist(playlistResp, user.GeneratedPlaylist)
-			shouldGenerate := false
-
-			if pls == nil {
-				missing = append(missing, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, user.GeneratedPlaylist))
-				shouldGenerate = true
-			} else if nowTs.Sub(pls.Changed) > 3*time.Hour {
-				olderThanThreeHours = append(olderThanThreeHours, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, user.GeneratedPlaylist))
-				shouldGenerate = true
-			}
-
-			if shouldGenerate {
-				jobs = append(jobs, Job{
-					JobType:     GenerateJams,
-					Username:    user.NDUsername,
-					LbzUsername: user.LbzUsername,
-					LbzToken:    user.LbzToken,
-					Ratings:     rating,
-					Generate: &generationJob{
-						Name:        user.GeneratedPlaylist,
-						ArtistLimit: user.GeneratedPlaylistArtistLimit,
-						TrackAge:    user.GeneratedPlaylistTrackAge,
-					},
+			job := userJob(user, FetchPatches)
+			job.Patch = &patchJob{
+				Sources: fetchedSources,
+			}
+			jobs = append(jobs, job)
+		}
+
+		// All generated playlists of a user share a single recommendation fetch
+		generated := []generationJob{}
+
+		for _, item := range user.generatedPlaylists() {
+			if !include(user.NDUsername, item.Name, item.Schedule) {
+				continue
+			}
+
+			if needsRebuild(user.NDUsername, item.Name, playlistResp) {
+				var itemRatings map[int32]bool
+				if len(item.Ratings) > 0 {
+					itemRatings = parseRatings(item.Ratings)
+				}
+
+				generated = append(generated, generationJob{
+					Name:          item.Name,
+					ArtistLimit:   item.ArtistLimit,
+					TrackAge:      item.TrackAge,
+					Size:          item.Size,
+					Duration:      item.Duration,
+					UnplayedRatio: item.UnplayedRatio,
+					Ratings:       itemRatings,
+					RepeatWindow:  item.RepeatWindow,
+					RepeatMode:    item.RepeatMode,
+					Source:        item.Source,
 				})
 			}
 		}
 
+		if len(generated) > 0 {
+			job := userJob(user, GenerateJams)
+			job.Generated = generated
+			jobs = append(jobs, job)
+		}
+
 		if len(user.Playlists) > 0 {
 			for _, item := range user.Playlists {
+				if !include(user.NDUsername, item.Name, item.Schedule) {
+					continue
+				}
+
 				pls := subsonic.FindExistingPlaylist(playlistResp, item.Name)
 				shouldImport := false
 
 				if pls == nil {
 					missing = append(missing, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, item.Name))
 					shouldImport = true
-				} else if !item.OneTime && nowTs.Sub(pls.Changed) > 3*time.Hour {
-					olderThanThreeHours = append(olderThanThreeHours, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, item.Name))
+					recordDecision(user.NDUsername, item.Name, statusQueued, "playlist missing", ledgerRetention)
+				} else if item.OneTime {
+					recordDecision(user.NDUsername, item.Name, statusSkipped, "one-time playlist already imported", ledgerRetention)
+				} else {
+					checked = append(checked, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, item.Name))
 					shouldImport = true
+					recordDecision(user.NDUsername, item.Name, statusQueued, "checking for upstream changes", ledgerRetention)
 				}
 
 				if shouldImport {
-					jobs = append(jobs, Job{
-						JobType:     ImportPlaylist,
-						Username:    user.NDUsername,
-						LbzUsername: user.LbzUsername,
-						LbzToken:    user.LbzToken,
-						Ratings:     rating,
-						Import: &importJob{
-							Name:  item.Name,
-							LbzId: item.LbzId,
-						},
-					})
+					job := userJob(user, ImportPlaylist)
+					job.Import = &importJob{
+						Name:  item.Name,
+						LbzId: item.LbzId,
+					}
+					jobs = append(jobs, job)
+				}
+			}
+		}
+
+		for _, item := range user.TopPlaylists {
+			if !include(user.NDUsername, item.Name, item.Schedule) {
+				continue
+			}
+
+			// Like imports, whether the statistics actually changed is decided when fetching
+			if subsonic.FindExistingPlaylist(playlistResp, item.Name) == nil {
+				missing = append(missing, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, item.Name))
+				recordDecision(user.NDUsername, item.Name, statusQueued, "playlist missing", ledgerRetention)
+			} else {
+				checked = append(checked, fmt.Sprintf("User: `%s`, Source: `%s`", user.NDUsername, item.Name))
+				recordDecision(user.NDUsername, item.Name, statusQueued, "checking for upstream changes", ledgerRetention)
+			}
+
+			job := userJob(user, TopTracks)
+			job.Top = &topJob{
+				Name:  item.Name,
+				Range: item.Range,
+				Size:  item.Size,
+			}
+			jobs = append(jobs, job)
+		}
+
+		if user.FreshReleasesPlaylist != "" && include(user.NDUsername, user.FreshReleasesPlaylist, user.FreshReleasesSchedule) {
+			name := user.FreshReleasesPlaylist
+
+			// Like generated playlists, this depends on the library as much as on ListenBrainz, so it is rebuilt when outdated
+			if needsRebuild(user.NDUsername, name, playlistResp) {
+				job := userJob(user, FreshReleases)
+				job.Fresh = &freshJob{
+					Name:   name,
+					Past:   user.FreshReleasesPast,
+					Future: user.FreshReleasesFuture,
 				}
+				jobs = append(jobs, job)
+			}
+		}
+
+		for _, item := range user.RadioPlaylists {
+			if !include(user.NDUsername, item.Name, item.Schedule) {
+				continue
+			}
+
+			// Every prompt generates a new playlist, so like generated playlists, it is only rebuilt when outdated
+			if needsRebuild(user.NDUsername, item.Name, playlistResp) {
+				job := userJob(user, Radio)
+				job.Radio = &radioJob{
+					Name:   item.Name,
+					Prompt: item.Prompt,
+					Mode:   item.Mode,
+				}
+				jobs = append(jobs, job)
+			}
+		}
+
+		// Mirrored playlists are only known once listed, so they are mirrored with the playlists without their own schedule
+		if user.MirrorPlaylists && include(user.NDUsername, "", "") {
+			job := userJob(user, Mirror)
+			job.Mirror = &mirrorJob{
+				NameTemplate: user.MirrorNameTemplate,
+				Delete:       user.MirrorDelete,
+				Reserved:     user.playlistNames(),
+			}
+			jobs = append(jobs, job)
+		}
+
+		// Like feedback, discovery is not tied to a playlist
+		if user.DiscoverPatches && include(user.NDUsername, "", "") {
+			jobs = append(jobs, userJob(user, DiscoverPatches))
+		}
+
+		// Feedback is not a playlist, so it is synced with the playlists without their own schedule
+		if user.SyncFeedback && include(user.NDUsername, "", "") {
+			job := userJob(user, SyncFeedback)
+			job.Feedback = &feedbackSyncJob{
+				HateRating: user.FeedbackHateRating,
 			}
+			jobs = append(jobs, job)
 		}
 	}
 
 	if len(jobs) > 0 {
 		pdk.Log(pdk.LogInfo,
-			fmt.Sprintf("Missing or outdated playlists, fetching on initial sync. Missing: %v, Outdated: %v",
+			fmt.Sprintf("Missing or outdated playlists, fetching on initial sync. Missing: %v, Outdated: %v, Checking for upstream changes: %v",
 				missing,
-				olderThanThreeHours,
+				outdated,
+				checked,
 			))
 
 		for _, job := range jobs {
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
 	"listenbrainz-daily-playlist/retry"
 	"listenbrainz-daily-playlist/sleep"
 	"listenbrainz-daily-playlist/subsonic"
@@ -40,9 +41,30 @@ var _ = Describe("Dispatcher", func() {
 		host.SubsonicAPIMock.ExpectedCalls = nil
 		host.TaskMock.Calls = nil
 		host.TaskMock.ExpectedCalls = nil
+		host.KVStoreMock.Calls = nil
+		host.KVStoreMock.ExpectedCalls = nil
 		pdk.PDKMock.On("Log", mock.Anything, mock.Anything).Maybe()
 	})
 
+	isStateKey := mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, "playlist/")
+	})
+
+	isEditionsKey := mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, "editions/")
+	})
+
+	mockNoEditions := func() {
+		host.KVStoreMock.On("Get", isEditionsKey).Return([]byte(nil), false, nil).Maybe()
+		host.KVStoreMock.On("Set", isEditionsKey, mock.Anything).Return(nil).Maybe()
+	}
+
+	mockNoPlaylistState := func() {
+		host.KVStoreMock.On("Get", isStateKey).Return([]byte(nil), false, nil).Maybe()
+		host.KVStoreMock.On("Set", isStateKey, mock.Anything).Return(nil).Maybe()
+		mockNoEditions()
+	}
+
 	mockUserConfig := func(path string) {
 		f, err := os.ReadFile("testdata/" + path + ".json")
 		if err != nil {
@@ -83,6 +105,83 @@ var _ = Describe("Dispatcher", func() {
 		})
 	})
 
+	Describe("getIntConfig", func() {
+		It("should use the default if the value is not set", func() {
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("", false)
+			Expect(getIntConfig("fallbackCount", defaultFallbackCount)).To(Equal(15))
+		})
+
+		It("should keep a value of 0", func() {
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			Expect(getIntConfig("fallbackCount", defaultFallbackCount)).To(Equal(0))
+		})
+
+		DescribeTable("should use the default for an invalid value", func(value string) {
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return(value, true)
+			Expect(getIntConfig("ledgerRetention", defaultLedgerRetention)).To(Equal(14))
+			pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogWarn, fmt.Sprintf("Invalid value for ledgerRetention `%s`, using the default of 14", value))
+		},
+			Entry("not a number", "abc"),
+			Entry("negative", "-1"),
+		)
+	})
+
+	Describe("userJob", func() {
+		It("should give every job type the settings of the user and the plugin", func() {
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("", false)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("24", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("7", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("true", true)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("true", true)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("true", true)
+
+			user := userConfig{
+				NDUsername:        "username",
+				LbzUsername:       "test",
+				LbzToken:          "1234",
+				Ratings:           []string{"5"},
+				ExcludeHated:      true,
+				BoostLoved:        true,
+				RecentListenLimit: 100,
+			}
+
+			for _, jobType := range []JobType{TopTracks, FreshReleases, SyncFeedback} {
+				Expect(userJob(user, jobType)).To(Equal(Job{
+					JobType:           jobType,
+					Username:          "username",
+					LbzUsername:       "test",
+					LbzToken:          "1234",
+					Ratings:           map[int32]bool{5: true},
+					FallbackCount:     15,
+					MatchCacheHours:   24,
+					MatchReports:      true,
+					RematchMissing:    true,
+					LedgerRetention:   7,
+					DryRun:            true,
+					ExcludeHated:      true,
+					BoostLoved:        true,
+					RecentListenLimit: 100,
+				}))
+			}
+		})
+	})
+
+	Describe("playlistNames", func() {
+		It("should list every configured playlist name other than templated source names", func() {
+			user := userConfig{
+				GeneratePlaylist:      true,
+				GeneratedPlaylist:     "Daily Jams",
+				Sources:               []source{{SourcePatch: "daily-jams", PlaylistName: "Jams"}, {SourcePatch: "weekly-*", PlaylistName: "{title}"}},
+				Playlists:             []playlist{{Name: "Imported", LbzId: "1234"}},
+				TopPlaylists:          []topPlaylist{{Name: "Top"}},
+				FreshReleasesPlaylist: "Fresh",
+				RadioPlaylists:        []radioPlaylist{{Name: "Radio"}},
+			}
+
+			Expect(user.playlistNames()).To(Equal([]string{"Jams", "Imported", "Daily Jams", "Top", "Fresh", "Radio"}))
+		})
+	})
+
 	Describe("GetConfig", func() {
 		DescribeTable("errors", func(path, error string) {
 			mockUserConfig(path)
@@ -110,6 +209,86 @@ var _ = Describe("Dispatcher", func() {
 				"userConfig.duplicatePatchAndImport",
 				"duplicate playlist name found: weekly name",
 			),
+			Entry(
+				"should reject a config with an invalid playlist schedule",
+				"userConfig.invalidSchedule",
+				"invalid schedule for playlist weekly name: unknown weekday `someday`",
+			),
+			Entry(
+				"should reject a config with an invalid cron expression",
+				"userConfig.invalidCron",
+				"invalid schedule for playlist weekly name: minute field `60` of cron expression `60 6 * * 1` must be between [0, 59], inclusive",
+			),
+			Entry(
+				"should reject a config where two generated playlists clash",
+				"userConfig.duplicateGenerated",
+				"duplicate playlist name found: Deep Cuts",
+			),
+			Entry(
+				"should reject a config with an unplayed ratio over 100",
+				"userConfig.invalidUnplayedRatio",
+				"unplayed ratio of playlist Deep Cuts must be between [0, 100], inclusive: 120",
+			),
+			Entry(
+				"should reject a forgotten favorites playlist without a track age",
+				"userConfig.forgottenWithoutTrackAge",
+				"forgotten favorites playlist Rediscover must exclude tracks played in the last X days",
+			),
+			Entry(
+				"should reject a top tracks playlist with an unknown range",
+				"userConfig.invalidTopRange",
+				"range of playlist My Top 50 must be one of week, month, quarter, year, all_time: decade",
+			),
+			Entry(
+				"should reject feedback sync without a ListenBrainz token",
+				"userConfig.feedbackWithoutToken",
+				"feedback sync for user username requires a ListenBrainz token",
+			),
+			Entry(
+				"should reject a radio playlist with an unknown mode",
+				"userConfig.invalidRadioMode",
+				"mode of radio playlist Shoegaze Radio must be one of easy, medium, hard: extreme",
+			),
+			Entry(
+				"should reject a radio playlist without a ListenBrainz token",
+				"userConfig.radioWithoutToken",
+				"radio playlist Shoegaze Radio requires a ListenBrainz token",
+			),
+			Entry(
+				"should reject a source pattern under a single playlist name",
+				"userConfig.patternWithoutTemplate",
+				"playlist name of source year-in-music-* must contain {title} or {patch}, as it can match several source patches: Year in Music",
+			),
+			Entry(
+				"should reject a malformed source pattern",
+				"userConfig.invalidPattern",
+				"source patch of playlist Year in Music is not a valid pattern: year-in-music-[",
+			),
+			Entry(
+				"should reject an unknown version preference",
+				"userConfig.invalidVersionPreference",
+				"version preference of user username must be one of library, release, noCompilations, original, quality: newest",
+			),
+			Entry(
+				"should reject a library preference without a library",
+				"userConfig.preferLibraryWithoutLibrary",
+				"preferring a library for user username requires a preferred library ID",
+			),
+			Entry(
+				"should reject a match override without a song or never",
+				"userConfig.overrideWithoutTarget",
+				"match override of user username for recording 9980309d-3480-4e7e-89ce-fce971a452be must either have a song ID or never match",
+			),
+			Entry(
+				"should reject two match overrides of the same recording",
+				"userConfig.duplicateOverride",
+				"duplicate match override of user username for recording 9980309d-3480-4e7e-89ce-fce971a452be",
+			),
+			Entry(
+				"should reject a mirrored playlist name template without the title",
+				"userConfig.invalidMirrorTemplate",
+				"mirrored playlist name template of user username must contain {title}: LB playlist",
+			),
 		)
 
 		It("should reject a config missing key users", func() {
@@ -162,7 +341,12 @@ var _ = Describe("Dispatcher", func() {
 
 		DescribeTable("dispatch rules", func(daily *time.Time, weekly *time.Time, generated *time.Time, imported *time.Time, log string) {
 			mockUserConfig("userConfig.complete")
-			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("", false)
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
 
 			now := time.Now()
 			playlists := []subsonic.Playlist{}
@@ -182,9 +366,8 @@ var _ = Describe("Dispatcher", func() {
 				})
 			}
 
-			if daily == nil || now.Sub(*daily) > 3*time.Hour {
-				sources = append(sources, source{SourcePatch: "daily-jams", PlaylistName: "playlist name"})
-			}
+			// Sources are always checked against ListenBrainz, regardless of age
+			sources = append(sources, source{SourcePatch: "daily-jams", PlaylistName: "playlist name"})
 
 			if weekly != nil {
 				playlists = append(playlists, subsonic.Playlist{
@@ -194,9 +377,7 @@ var _ = Describe("Dispatcher", func() {
 				})
 			}
 
-			if weekly == nil || now.Sub(*weekly) > 3*time.Hour {
-				sources = append(sources, source{SourcePatch: "weekly-jams", PlaylistName: "weekly name"})
-			}
+			sources = append(sources, source{SourcePatch: "weekly-jams", PlaylistName: "weekly name"})
 
 			if len(sources) > 0 {
 				j := Job{
@@ -228,11 +409,11 @@ var _ = Describe("Dispatcher", func() {
 					LbzUsername: "lbz username",
 					LbzToken:    "1234",
 					Ratings:     ratings,
-					Generate: &generationJob{
+					Generated: []generationJob{{
 						Name:        "Generated Daily Jams",
 						TrackAge:    60,
 						ArtistLimit: 15,
-					},
+					}},
 				}
 
 				generatePayload, err = json.Marshal(j)
@@ -248,21 +429,20 @@ var _ = Describe("Dispatcher", func() {
 				})
 			}
 
-			if imported == nil || now.Sub(*imported) > 3*time.Hour {
-				j := Job{
-					JobType:     ImportPlaylist,
-					Username:    "username",
-					LbzUsername: "lbz username",
-					LbzToken:    "1234",
-					Ratings:     ratings,
-					Import:      &importJob{Name: "1234", LbzId: "0"},
-				}
-
-				importPayload, err = json.Marshal(j)
-				Expect(err).To(BeNil())
-				host.TaskMock.On("Enqueue", "job-queue", importPayload).Return("", nil)
+			// Imports are always checked against ListenBrainz, regardless of age
+			importPlaylistJob := Job{
+				JobType:     ImportPlaylist,
+				Username:    "username",
+				LbzUsername: "lbz username",
+				LbzToken:    "1234",
+				Ratings:     ratings,
+				Import:      &importJob{Name: "1234", LbzId: "0"},
 			}
 
+			importPayload, err = json.Marshal(importPlaylistJob)
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", importPayload).Return("", nil)
+
 			resp := subsonic.JsonWrapper{
 				Subsonic: subsonic.Subsonic{
 					Status:    "ok",
@@ -292,195 +472,2030 @@ var _ = Describe("Dispatcher", func() {
 		},
 			Entry(
 				"no playlists exist", nil, nil, nil, nil,
-				"Missing or outdated playlists, fetching on initial sync. Missing: [User: `username`, Source: `playlist name` User: `username`, Source: `weekly name` User: `username`, Source: `Generated Daily Jams` User: `username`, Source: `1234`], Outdated: []",
+				"Missing or outdated playlists, fetching on initial sync. Missing: [User: `username`, Source: `playlist name` User: `username`, Source: `weekly name` User: `username`, Source: `Generated Daily Jams` User: `username`, Source: `1234`], Outdated: [], Checking for upstream changes: []",
 			),
 			Entry(
-				"playlists exist but are all old", &time.Time{}, &time.Time{}, &time.Time{}, &time.Time{}, "Missing or outdated playlists, fetching on initial sync. Missing: [], Outdated: [User: `username`, Source: `playlist name` User: `username`, Source: `weekly name` User: `username`, Source: `Generated Daily Jams` User: `username`, Source: `1234`]",
+				"playlists exist but are all old", &time.Time{}, &time.Time{}, &time.Time{}, &time.Time{}, "Missing or outdated playlists, fetching on initial sync. Missing: [], Outdated: [User: `username`, Source: `Generated Daily Jams`], Checking for upstream changes: [User: `username`, Source: `playlist name` User: `username`, Source: `weekly name` User: `username`, Source: `1234`]",
 			),
 			Entry(
-				"all playlists are recent", now(), now(), now(), now(),
-				"No missing/outdated playlists, not fetching",
+				"all playlists are recent, imports are still checked upstream", now(), now(), now(), now(),
+				"Missing or outdated playlists, fetching on initial sync. Missing: [], Outdated: [], Checking for upstream changes: [User: `username`, Source: `playlist name` User: `username`, Source: `weekly name` User: `username`, Source: `1234`]",
 			),
 			Entry(
 				"imports with at least one source present", nil, now(), now(), &time.Time{},
-				"Missing or outdated playlists, fetching on initial sync. Missing: [User: `username`, Source: `playlist name`], Outdated: [User: `username`, Source: `1234`]",
+				"Missing or outdated playlists, fetching on initial sync. Missing: [User: `username`, Source: `playlist name`], Outdated: [], Checking for upstream changes: [User: `username`, Source: `weekly name` User: `username`, Source: `1234`]",
 			),
 		)
 	})
 
-	Describe("ClearQueue", func() {
-		It("should successfully clear queue", func() {
-			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(1), nil)
-			ClearQueue()
-			host.TaskMock.AssertCalled(GinkgoT(), "ClearQueue", "job-queue")
-			pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogInfo, "Removed 1 job(s) from task queue")
-		})
+	Describe("schedules", func() {
+		DescribeTable("cronExpression", func(schedule, expected, errMsg string) {
+			cron, err := cronExpression(schedule, 8)
+			if errMsg != "" {
+				Expect(err).To(MatchError(errMsg))
+			} else {
+				Expect(err).To(BeNil())
+			}
+			Expect(cron).To(Equal(expected))
+		},
+			Entry("no schedule", "", "", ""),
+			Entry("daily at the default hour", "daily", "0~59 8 * * *", ""),
+			Entry("daily at a specific hour", "Daily@14", "0~59 14 * * *", ""),
+			Entry("weekly by full weekday", "weekly:monday", "0~59 8 * * 1", ""),
+			Entry("weekly by short weekday and hour", "weekly:sun@0", "0~59 0 * * 0", ""),
+			Entry("cron expression", "30 6 * * 1,4", "30 6 * * 1,4", ""),
+			Entry("cron expression with ranges, steps and names", "0~59 */6 1-15 jan-jun mon", "0~59 */6 1-15 jan-jun mon", ""),
+			Entry("cron expression with too few fields", "30 6 * *", "", "cron expression `30 6 * *` must have 5 fields (minute, hour, day of month, month, day of week), got 4"),
+			Entry("cron expression out of range", "30 25 * * *", "", "hour field `25` of cron expression `30 25 * * *` must be between [0, 23], inclusive"),
+			Entry("cron expression with a reversed range", "0 0 * * 5-1", "", "day of week field `5-1` of cron expression `0 0 * * 5-1` must be between [0, 7], inclusive"),
+			Entry("cron expression with an invalid step", "*/0 * * * *", "", "invalid step `0` in minute field `*/0` of cron expression `*/0 * * * *`"),
+			Entry("unknown cadence", "monthly", "", "expected a cron expression, `daily` or `weekly:<weekday>`, got `monthly`"),
+			Entry("unknown weekday", "weekly:someday", "", "unknown weekday `someday`"),
+			Entry("invalid hour", "daily@24", "", "hour is not valid (between [0, 23], inclusive): 24"),
+		)
 
-		It("should ignore clear error but log in error", func() {
-			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(0), errors.New("Error"))
-			ClearQueue()
-			host.TaskMock.AssertCalled(GinkgoT(), "ClearQueue", "job-queue")
-			pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogError, "Failed to clear task queue: Error")
-		})
-	})
+		It("should register a callback for each playlist with a schedule", func() {
+			mockUserConfig("userConfig.scheduled")
+			users, err := GetConfig()
+			Expect(err).To(BeNil())
 
-	Describe("dispatching", func() {
-		const lbzEndpoint = "https://api.listenbrainz.org/1"
-		var job Job
+			host.SchedulerMock.Calls = nil
+			host.SchedulerMock.ExpectedCalls = nil
+			host.SchedulerMock.On("ScheduleRecurring", "0~59 6 * * 1", `{"username":"username","playlist":"weekly name"}`, "schedule/username/weekly%20name").Return("1", nil)
+			host.SchedulerMock.On("ScheduleRecurring", "0 */6 * * *", `{"username":"username","playlist":"Generated Daily Jams"}`, "schedule/username/Generated%20Daily%20Jams").Return("2", nil)
+			host.SchedulerMock.On("ScheduleRecurring", "0~59 7 * * *", `{"username":"username","playlist":"1234"}`, "schedule/username/1234").Return("3", nil)
 
-		mockSleep := func(d time.Duration) {}
+			err = SchedulePlaylists(users, 7)
+			Expect(err).To(BeNil())
+			Expect(host.SchedulerMock.Calls).To(HaveLen(3))
+		})
 
-		BeforeEach(func() {
-			job = Job{Username: "username"}
-			oldSleep := sleep.Sleep
-			sleep.Sleep = mockSleep
+		It("should fail if a playlist cannot be scheduled", func() {
+			mockUserConfig("userConfig.scheduled")
+			users, err := GetConfig()
+			Expect(err).To(BeNil())
 
-			pdk.ResetMock()
-			pdk.PDKMock.Calls = nil
-			pdk.PDKMock.ExpectedCalls = nil
-			host.HTTPMock.Calls = nil
-			host.HTTPMock.ExpectedCalls = nil
-			host.TaskMock.Calls = nil
-			host.TaskMock.ExpectedCalls = nil
-			host.MatcherMock.Calls = nil
-			host.MatcherMock.ExpectedCalls = nil
-			host.SubsonicAPIMock.Calls = nil
-			host.SubsonicAPIMock.ExpectedCalls = nil
-			pdk.PDKMock.On("Log", mock.Anything, mock.Anything).Maybe()
+			host.SchedulerMock.Calls = nil
+			host.SchedulerMock.ExpectedCalls = nil
+			host.SchedulerMock.On("ScheduleRecurring", mock.Anything, mock.Anything, mock.Anything).Return("", errors.New("error"))
 
-			DeferCleanup(func() {
-				sleep.Sleep = oldSleep
-			})
+			err = SchedulePlaylists(users, 7)
+			Expect(err).To(MatchError("failed to schedule sync of playlist weekly name for user username. Is `0~59 6 * * 1` a valid cron expression? error"))
 		})
 
-		setupResponse := func(request host.HTTPRequest, code int, dataPath string, err error, rateLimited bool) {
-			host.HTTPMock.On("Send", request).Return(testdata.MakeLbzResponse(code, dataPath+".json", err, rateLimited))
-		}
-
-		Describe("dispatchSourceFetching", func() {
-			const URL = lbzEndpoint + "/user/test/playlists/createdfor"
+		Describe("fetching", func() {
+			enqueuedJobs := func() []Job {
+				jobs := []Job{}
+				for _, call := range host.TaskMock.Calls {
+					var job Job
+					Expect(json.Unmarshal(call.Arguments.Get(1).([]byte), &job)).To(Succeed())
+					jobs = append(jobs, job)
+				}
+				return jobs
+			}
 
 			BeforeEach(func() {
-				job.JobType = FetchPatches
+				mockUserConfig("userConfig.scheduled")
+				pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+				pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+				pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+				pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+				pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+				pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+				host.TaskMock.On("Enqueue", "job-queue", mock.Anything).Return("", nil)
 			})
 
-			It("should error if patch is missing", func() {
-				err := job.Dispatch()
-				Expect(err).To(Equal(retry.FatalError("attempting to dispatch patch fetch without patch")))
+			It("should only sync playlists without their own schedule daily", func() {
+				err := DailyFetch()
+				Expect(err).To(BeNil())
+
+				jobs := enqueuedJobs()
+				Expect(jobs).To(HaveLen(1))
+				Expect(jobs[0].JobType).To(Equal(FetchPatches))
+				Expect(jobs[0].Patch.Sources).To(Equal([]source{{SourcePatch: "daily-jams", PlaylistName: "playlist name"}}))
 			})
 
-			It("should fail if LBZ response is bad", func() {
-				job.LbzUsername = "a"
-				job.Patch = &patchJob{Sources: []source{{SourcePatch: "daily-jams", PlaylistName: "daily-jams"}}}
+			It("should only sync the scheduled playlist on its callback", func() {
+				err := ScheduledFetch(`{"username":"username","playlist":"weekly name"}`)
+				Expect(err).To(BeNil())
 
-				url := lbzEndpoint + "/user/a/playlists/createdfor"
-				request := testdata.MakeLbzRequest(url, "", nil)
-				setupResponse(request, 404, "createdFor.noUser", nil, false)
-				err := job.Dispatch()
-				Expect(err).To(Equal(retry.FatalError("ListenBrainz HTTP Error. Code: 404, Error: Cannot find user: a")))
+				jobs := enqueuedJobs()
+				Expect(jobs).To(HaveLen(1))
+				Expect(jobs[0].JobType).To(Equal(FetchPatches))
+				Expect(jobs[0].Patch.Sources).To(Equal([]source{{SourcePatch: "weekly-jams", PlaylistName: "weekly name", Schedule: "weekly:monday@6"}}))
 			})
 
-			DescribeTable("should issue a retry if present", func(recoverable error) {
-				job.LbzUsername = "a"
-				job.Patch = &patchJob{Sources: []source{{SourcePatch: "daily-jams", PlaylistName: "daily-jams"}}}
-
-				url := lbzEndpoint + "/user/a/playlists/createdfor"
-				request := testdata.MakeLbzRequest(url, "", nil)
-				setupResponse(request, 0, "", recoverable, false)
-				err := job.Dispatch()
-				Expect(err).To(Equal(retry.TempError(recoverable)))
-			},
-				Entry("connection reset", CONNECTION_RESET),
-				Entry("context deadline", CONTEXT_DEADLINE),
-			)
+			It("should sync every playlist on startup", func() {
+				err := InitialFetch()
+				Expect(err).To(BeNil())
+				Expect(enqueuedJobs()).To(HaveLen(3))
+			})
 
-			It("should error if no playlist found", func() {
-				job.LbzUsername = "test"
-				job.Patch = &patchJob{Sources: []source{{SourcePatch: "daily-jams", PlaylistName: "daily-jams"}}}
+			It("should reject an invalid callback payload", func() {
+				err := ScheduledFetch("daily")
+				Expect(err).To(MatchError(ContainSubstring("invalid scheduler payload `daily`")))
+			})
+		})
+	})
 
-				request := testdata.MakeLbzRequest(URL, "", nil)
-				setupResponse(request, 200, "createdFor.success", nil, false)
-				err := job.Dispatch()
+	Describe("multiple generated playlists", func() {
+		It("should queue a single job for all generated playlists of a user", func() {
+			mockUserConfig("userConfig.multipleGenerated")
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+			testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+			expected, err := json.Marshal(Job{
+				JobType:     GenerateJams,
+				Username:    "username",
+				LbzUsername: "lbz username",
+				LbzToken:    "1234",
+				Ratings:     map[int32]bool{0: true, 2: true, 3: true, 4: true, 5: true},
+				Generated: []generationJob{
+					{Name: "Generated Daily Jams", TrackAge: 60, ArtistLimit: 15},
+					{Name: "Deep Cuts", Size: 30, ArtistLimit: 1, UnplayedRatio: 80, Ratings: map[int32]bool{0: true}},
+					{Name: "Familiar Favorites", Duration: 240, TrackAge: 30, ArtistLimit: 3, Ratings: map[int32]bool{4: true, 5: true}},
+				},
+			})
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", expected).Return("", nil)
 
-				Expect(err).ToNot(BeNil())
-				Expect(err.Retryable).To(BeFalse())
-				Expect(err.Error.Error()).To(Equal("no playlist for ListenBrainz user `test` found with algorithm/source patch `daily-jams`"))
+			err = InitialFetch()
+			Expect(err).To(BeNil())
+			host.TaskMock.AssertCalled(GinkgoT(), "Enqueue", "job-queue", expected)
+			Expect(host.TaskMock.Calls).To(HaveLen(1))
+		})
+	})
 
-				Expect(host.TaskMock.Calls).To(BeNil())
+	Describe("top tracks playlists", func() {
+		It("should queue a job for each top playlist due", func() {
+			mockUserConfig("userConfig.top")
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+			testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+			expected, err := json.Marshal(Job{
+				JobType:     TopTracks,
+				Username:    "username",
+				LbzUsername: "lbz username",
+				LbzToken:    "1234",
+				Ratings:     map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true},
+				Top:         &topJob{Name: "My Top 50 This Month", Range: "month", Size: 50},
 			})
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", expected).Return("", nil)
 
-			It("should find real playlist, retry on enqueue error", func() {
-				job.LbzUsername = "test"
-				job.Patch = &patchJob{Sources: []source{{SourcePatch: "weekly-exploration", PlaylistName: "daily-jams"}}}
-
-				request := testdata.MakeLbzRequest(URL, "", nil)
-				setupResponse(request, 200, "createdFor.success", nil, false)
+			err = DailyFetch()
+			Expect(err).To(BeNil())
+			host.TaskMock.AssertCalled(GinkgoT(), "Enqueue", "job-queue", expected)
+			Expect(host.TaskMock.Calls).To(HaveLen(1))
+		})
+	})
 
-				dispatched := Job{
-					JobType:     ImportPlaylist,
-					Username:    "username",
-					LbzUsername: "test",
-					Import: &importJob{
-						Name:  "daily-jams",
-						LbzId: EMPTY_UUID,
-					},
-				}
+	Describe("radio playlists", func() {
+		It("should queue a job for a missing radio playlist", func() {
+			mockUserConfig("userConfig.radio")
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+			testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+			expected, err := json.Marshal(Job{
+				JobType:     Radio,
+				Username:    "username",
+				LbzUsername: "lbz username",
+				LbzToken:    "1234",
+				Ratings:     map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true},
+				Radio:       &radioJob{Name: "Shoegaze Radio", Prompt: "tag:(shoegaze)", Mode: "medium"},
+			})
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", expected).Return("", nil)
 
-				dispatchPayload, marshalErr := json.Marshal(dispatched)
-				Expect(marshalErr).To(BeNil())
-				host.TaskMock.On("Enqueue", "job-queue", dispatchPayload).Return("", errors.New("nope"))
+			err = DailyFetch()
+			Expect(err).To(BeNil())
+			host.TaskMock.AssertCalled(GinkgoT(), "Enqueue", "job-queue", expected)
+			Expect(host.TaskMock.Calls).To(HaveLen(1))
+		})
+	})
 
-				err := job.Dispatch()
-				Expect(err).To(Equal(retry.TempError(errors.New("nope"))))
-				Expect(host.TaskMock.Calls).To(HaveLen(1))
+	Describe("mirrored playlists", func() {
+		It("should queue a mirror job with the playlists without their own schedule", func() {
+			mockUserConfig("userConfig.mirror")
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+			testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+			expected, err := json.Marshal(Job{
+				JobType:     Mirror,
+				Username:    "username",
+				LbzUsername: "lbz username",
+				LbzToken:    "1234",
+				Ratings:     map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true},
+				Mirror:      &mirrorJob{NameTemplate: "LB: {title}", Delete: true},
 			})
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", expected).Return("", nil)
 
-			It("should find real playlist, succeed on shipping task, full job", func() {
-				job.LbzUsername = "test"
-				job.Patch = &patchJob{Sources: []source{
-					{SourcePatch: "weekly-exploration", PlaylistName: "weekly exploration"},
-					{SourcePatch: "daily-jams", PlaylistName: "daily jams"},
-				}}
-				job.LbzToken = "1234"
-				job.Ratings = map[int32]bool{int32(5): true}
+			err = DailyFetch()
+			Expect(err).To(BeNil())
+			host.TaskMock.AssertCalled(GinkgoT(), "Enqueue", "job-queue", expected)
+			Expect(host.TaskMock.Calls).To(HaveLen(1))
+		})
+	})
 
-				url := lbzEndpoint + "/user/test/playlists/createdfor"
-				request := testdata.MakeLbzRequest(url, "1234", nil)
-				setupResponse(request, 200, "createdFor.success", nil, false)
+	Describe("rematching missing tracks", func() {
+		BeforeEach(func() {
+			host.LibraryMock.Calls = nil
+			host.LibraryMock.ExpectedCalls = nil
+			host.LibraryMock.On("GetAllLibraries").Return([]host.Library{{ID: 1, LastScanAt: 100}}, nil)
+		})
 
-				dispatched := Job{
-					JobType:     ImportPlaylist,
-					Username:    "username",
-					LbzUsername: "test",
-					LbzToken:    "1234",
-					Ratings:     map[int32]bool{int32(5): true},
-					Import: &importJob{
-						Name:  "weekly exploration",
-						LbzId: EMPTY_UUID,
-					},
-				}
+		It("should not queue jobs without a new library scan", func() {
+			host.KVStoreMock.On("Get", "rematch/scannedAt").Return([]byte("100"), true, nil)
 
-				dispatchPayload, marshalErr := json.Marshal(dispatched)
-				Expect(marshalErr).To(BeNil())
-				host.TaskMock.On("Enqueue", "job-queue", dispatchPayload).Return("", nil)
+			err := CheckMissing()
+			Expect(err).To(BeNil())
+			Expect(host.TaskMock.Calls).To(BeEmpty())
+		})
 
-				err := job.Dispatch()
-				Expect(err).ToNot(BeNil())
-				Expect(err.Retryable).To(BeFalse())
-				Expect(err.Error.Error()).To(Equal("no playlist for ListenBrainz user `test` found with algorithm/source patch `daily-jams`"))
-				Expect(host.TaskMock.Calls).To(HaveLen(1))
+		It("should queue a job for every user with missing tracks after a library scan", func() {
+			mockUserConfig("userConfig.mirror")
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("", false)
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("15", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("true", true)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+			host.KVStoreMock.On("Get", "rematch/scannedAt").Return([]byte("50"), true, nil)
+			host.KVStoreMock.On("List", "gaps/username/").Return([]string{"gaps/username/LB%3A%20Weekly"}, nil)
+			host.KVStoreMock.On("Set", "rematch/scannedAt", []byte("100")).Return(nil)
+
+			expected, err := json.Marshal(Job{
+				JobType:        Rematch,
+				Username:       "username",
+				LbzUsername:    "lbz username",
+				LbzToken:       "1234",
+				Ratings:        map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true},
+				MatchReports:   true,
+				RematchMissing: true,
 			})
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", expected).Return("", nil)
+
+			err = CheckMissing()
+			Expect(err).To(BeNil())
+			host.TaskMock.AssertCalled(GinkgoT(), "Enqueue", "job-queue", expected)
+			host.KVStoreMock.AssertCalled(GinkgoT(), "Set", "rematch/scannedAt", []byte("100"))
 		})
+	})
 
-		Describe("dispatchImport", func() {
-			// Note, I will not be testing the "updatePlaylist" subsonic call here
-			// I am assuming it just works in general (or fails).
-			const URL = lbzEndpoint + "/playlist/" + EMPTY_UUID
-			var (
-				SINGLE_ARTIST    = types.SongRef{Name: "world.execute(me);", MBID: "9980309d-3480-4e7e-89ce-fce971a452be", Artists: []types.ArtistRef{{Name: "Mili", MBID: "d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"}}, Album: "Miracle Milk", DurationMs: 211912}
-				MULTIPLE_ARTISTS = types.SongRef{
-					Name:       "イザナ平原/夜",
-					MBID:       "7e4bb014-51d5-4943-adb1-683e066a5220",
+	Describe("ledger", func() {
+		It("should record decisions made by InitialFetch", func() {
+			mockUserConfig("userConfig.complete")
+			pdk.PDKMock.On("GetConfig", "fallbackCount").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "matchCacheHours").Return("0", true)
+			pdk.PDKMock.On("GetConfig", "ledgerRetention").Return("7", true)
+			pdk.PDKMock.On("GetConfig", "dryRun").Return("false", true)
+			pdk.PDKMock.On("GetConfig", "matchReports").Return("", false)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
+
+			resp := subsonic.JsonWrapper{
+				Subsonic: subsonic.Subsonic{
+					Status: "ok",
+					Playlists: &subsonic.Playlists{Playlist: []subsonic.Playlist{
+						{Id: "12", Name: "playlist name", Changed: time.Now()},
+						{Id: "34", Name: "weekly name", Changed: time.Now()},
+						{Id: "56", Name: "Generated Daily Jams", Changed: time.Now()},
+						{Id: "78", Name: "1234", Changed: time.Now()},
+					}},
+				},
+			}
+
+			payload, err := json.Marshal(resp)
+			Expect(err).To(BeNil())
+			host.SubsonicAPIMock.On("Call", "/rest/getPlaylists?u=username&username=username").Return(string(payload), nil)
+
+			var entries []ledgerEntry
+			host.KVStoreMock.On("SetWithTTL", mock.MatchedBy(func(key string) bool {
+				return strings.HasPrefix(key, "ledger/username/")
+			}), mock.Anything, int64(7*24*60*60)).Run(func(args mock.Arguments) {
+				var entry ledgerEntry
+				Expect(json.Unmarshal(args.Get(1).([]byte), &entry)).To(Succeed())
+				entries = append(entries, entry)
+			}).Return(nil)
+
+			host.TaskMock.On("Enqueue", "job-queue", mock.Anything).Return("", nil)
+
+			err = InitialFetch()
+			Expect(err).To(BeNil())
+
+			Expect(entries).To(HaveLen(4))
+			for _, entry := range entries {
+				Expect(entry.JobType).To(Equal(initialFetch))
+
+				if entry.Playlist == "Generated Daily Jams" {
+					Expect(entry.Status).To(Equal(statusSkipped))
+					Expect(entry.Message).To(Equal("playlist up to date"))
+				} else {
+					Expect(entry.Status).To(Equal(statusQueued))
+					Expect(entry.Message).To(Equal("checking for upstream changes"))
+				}
+			}
+
+			// One patch fetch, and one import by ID
+			Expect(host.TaskMock.Calls).To(HaveLen(2))
+		})
+
+		It("should record a failed source per playlist", func() {
+			mockNoPlaylistState()
+			job := Job{
+				JobType:         FetchPatches,
+				Username:        "username",
+				LbzUsername:     "test",
+				LedgerRetention: 3,
+				Patch: &patchJob{Sources: []source{
+					{SourcePatch: "weekly-exploration", PlaylistName: "weekly exploration"},
+					{SourcePatch: "daily-jams", PlaylistName: "daily jams"},
+				}},
+			}
+
+			request := testdata.MakeLbzRequest("https://api.listenbrainz.org/1/user/test/playlists/createdfor?count=100&offset=0", "", nil)
+			host.HTTPMock.On("Send", request).Return(testdata.MakeLbzResponse(200, "createdFor.success.json", nil, false))
+			host.TaskMock.On("Enqueue", "job-queue", mock.Anything).Return("", nil)
+
+			entries := map[string]ledgerEntry{}
+			host.KVStoreMock.On("SetWithTTL", mock.Anything, mock.Anything, int64(3*24*60*60)).Run(func(args mock.Arguments) {
+				var entry ledgerEntry
+				Expect(json.Unmarshal(args.Get(1).([]byte), &entry)).To(Succeed())
+				Expect(args.String(0)).To(HavePrefix("ledger/username/" + url.PathEscape(entry.Playlist) + "/"))
+				entries[entry.Playlist] = entry
+			}).Return(nil)
+
+			err := job.Dispatch()
+			Expect(err).ToNot(BeNil())
+
+			Expect(entries).To(HaveLen(2))
+			Expect(entries["weekly exploration"].Status).To(Equal(statusQueued))
+			Expect(entries["weekly exploration"].LbzId).To(Equal(EMPTY_UUID))
+			Expect(entries["daily jams"].Status).To(Equal(statusFailed))
+			Expect(entries["daily jams"].Error).To(Equal("no playlist for ListenBrainz user `test` found with algorithm/source patch `daily-jams`"))
+		})
+	})
+
+	Describe("selectTracks", func() {
+		makeTracks := func(prefix string, count int, artist string, duration float64) []*types.Track {
+			tracks := make([]*types.Track, count)
+			for idx := range tracks {
+				tracks[idx] = &types.Track{
+					ID:           fmt.Sprintf("%s-%d", prefix, idx),
+					Duration:     duration,
+					Participants: []types.ArtistRef{{ID: artist, Role: "artist"}},
+				}
+			}
+			return tracks
+		}
+
+		ids := func(tracks []*types.Track) []string {
+			result := make([]string, len(tracks))
+			for idx, track := range tracks {
+				result[idx] = track.ID
+			}
+			return result
+		}
+
+		It("should default to 50 tracks", func() {
+			g := generationJob{}
+			songs := g.selectTracks(makeTracks("played", 40, "a", 180), makeTracks("new", 40, "b", 180))
+			Expect(songs).To(HaveLen(50))
+			Expect(songs[39].ID).To(Equal("played-39"))
+			Expect(songs[40].ID).To(Equal("new-0"))
+		})
+
+		It("should respect a configured size", func() {
+			g := generationJob{Size: 20}
+			songs := g.selectTracks(makeTracks("played", 40, "a", 180), nil)
+			Expect(ids(songs)).To(Equal(ids(makeTracks("played", 20, "a", 180))))
+		})
+
+		It("should fill up to a target duration", func() {
+			// 60 minutes of 4 minute tracks is 15 tracks, regardless of size
+			g := generationJob{Size: 5, Duration: 60}
+			songs := g.selectTracks(makeTracks("played", 10, "a", 240), makeTracks("new", 10, "b", 240))
+			Expect(songs).To(HaveLen(15))
+			Expect(songs[14].ID).To(Equal("new-4"))
+		})
+
+		It("should reserve a share for unplayed tracks", func() {
+			g := generationJob{Size: 10, UnplayedRatio: 30}
+			songs := g.selectTracks(makeTracks("played", 20, "a", 180), makeTracks("new", 20, "b", 180))
+			Expect(ids(songs)).To(Equal(append(ids(makeTracks("played", 7, "a", 180)), ids(makeTracks("new", 3, "b", 180))...)))
+		})
+
+		It("should fill a missing unplayed share with played tracks", func() {
+			g := generationJob{Size: 10, UnplayedRatio: 50}
+			songs := g.selectTracks(makeTracks("played", 20, "a", 180), makeTracks("new", 2, "b", 180))
+			Expect(ids(songs)).To(Equal([]string{"played-0", "played-1", "played-2", "played-3", "played-4", "new-0", "new-1", "played-5", "played-6", "played-7"}))
+		})
+
+		It("should split a duration target by the unplayed ratio", func() {
+			// 30 minutes of 3 minute tracks, half of which are unplayed
+			g := generationJob{Duration: 30, UnplayedRatio: 50}
+			songs := g.selectTracks(makeTracks("played", 20, "a", 180), makeTracks("new", 20, "b", 180))
+			Expect(ids(songs)).To(Equal(append(ids(makeTracks("played", 5, "a", 180)), ids(makeTracks("new", 5, "b", 180))...)))
+		})
+
+		It("should apply the artist limit", func() {
+			g := generationJob{Size: 10, ArtistLimit: 2}
+			played := append(makeTracks("a", 5, "a", 180), makeTracks("b", 5, "b", 180)...)
+			songs := g.selectTracks(played, makeTracks("c", 5, "c", 180))
+			Expect(ids(songs)).To(Equal([]string{"a-0", "a-1", "b-0", "b-1"}))
+		})
+	})
+
+	Describe("weightedShuffle", func() {
+		tracks := []*types.Track{{ID: "low"}, {ID: "medium"}, {ID: "high"}}
+		weights := map[*types.Track]float64{tracks[0]: 0.1, tracks[1]: 1, tracks[2]: 10}
+
+		It("should be deterministic for a seed", func() {
+			Expect(weightedShuffle(tracks, weights, 42)).To(Equal(weightedShuffle(tracks, weights, 42)))
+		})
+
+		It("should not modify the input", func() {
+			weightedShuffle(tracks, weights, 42)
+			Expect(tracks[0].ID).To(Equal("low"))
+		})
+
+		It("should favor tracks with a higher score", func() {
+			first := map[string]int{}
+			for seed := range int64(1000) {
+				first[weightedShuffle(tracks, weights, seed)[0].ID] += 1
+			}
+
+			Expect(first["high"]).To(BeNumerically(">", 800))
+			Expect(first["high"]).To(BeNumerically("<", 1000))
+			Expect(first["low"]).To(BeNumerically("<", first["medium"]))
+		})
+
+		It("should use the same seed for a playlist for the whole day", func() {
+			morning := time.Date(2026, 3, 1, 8, 0, 0, 0, time.Local)
+			evening := time.Date(2026, 3, 1, 20, 0, 0, 0, time.Local)
+			tomorrow := morning.AddDate(0, 0, 1)
+
+			Expect(daySeed("user", "Jams", morning)).To(Equal(daySeed("user", "Jams", evening)))
+			Expect(daySeed("user", "Jams", morning)).ToNot(Equal(daySeed("user", "Jams", tomorrow)))
+			Expect(daySeed("user", "Jams", morning)).ToNot(Equal(daySeed("user", "Other", morning)))
+		})
+	})
+
+	Describe("history", func() {
+		now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.Local)
+		history := []historyDay{
+			{Date: "2026-03-01", SongIds: []string{"old"}},
+			{Date: "2026-03-07", SongIds: []string{"a", "b"}},
+			{Date: "2026-03-09", SongIds: []string{"c"}},
+			{Date: "2026-03-10", SongIds: []string{"today"}},
+		}
+
+		mockHistory := func() {
+			payload, err := json.Marshal(history)
+			Expect(err).To(BeNil())
+			host.KVStoreMock.On("Get", "history/username/Jams").Return(payload, true, nil)
+		}
+
+		It("should return songs generated in the window, excluding today", func() {
+			mockHistory()
+			Expect(recentlyGenerated("username", "Jams", now, 3)).To(Equal(map[string]bool{"a": true, "b": true, "c": true}))
+			Expect(recentlyGenerated("username", "Jams", now, 1)).To(Equal(map[string]bool{"c": true}))
+		})
+
+		It("should replace today and forget days outside the window", func() {
+			mockHistory()
+
+			var saved []historyDay
+			host.KVStoreMock.On("Set", "history/username/Jams", mock.Anything).Run(func(args mock.Arguments) {
+				Expect(json.Unmarshal(args.Get(1).([]byte), &saved)).To(Succeed())
+			}).Return(nil)
+
+			saveHistory("username", "Jams", now, 3, []*types.Track{{ID: "x"}, {ID: "y"}})
+			Expect(saved).To(Equal([]historyDay{
+				{Date: "2026-03-07", SongIds: []string{"a", "b"}},
+				{Date: "2026-03-09", SongIds: []string{"c"}},
+				{Date: "2026-03-10", SongIds: []string{"x", "y"}},
+			}))
+		})
+
+		It("should start a new history", func() {
+			host.KVStoreMock.On("Get", "history/username/Jams").Return([]byte(nil), false, nil)
+			host.KVStoreMock.On("Set", "history/username/Jams", []byte(`[{"date":"2026-03-10","songIds":["x"]}]`)).Return(nil)
+
+			saveHistory("username", "Jams", now, 3, []*types.Track{{ID: "x"}})
+			host.KVStoreMock.AssertCalled(GinkgoT(), "Set", "history/username/Jams", []byte(`[{"date":"2026-03-10","songIds":["x"]}]`))
+		})
+
+		It("should exclude or down-weight repeats", func() {
+			tracks := []*types.Track{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+			recent := map[string]bool{"b": true}
+
+			weights := map[*types.Track]float64{tracks[0]: 1, tracks[1]: 1, tracks[2]: 1}
+			g := generationJob{RepeatMode: repeatExclude}
+			kept, count := g.avoidRepeats(tracks, weights, recent)
+			Expect(kept).To(Equal([]*types.Track{tracks[0], tracks[2]}))
+			Expect(count).To(Equal(1))
+
+			g = generationJob{RepeatMode: repeatDownweight}
+			kept, count = g.avoidRepeats(tracks, weights, recent)
+			Expect(kept).To(Equal(tracks))
+			Expect(count).To(Equal(0))
+			Expect(weights[tracks[1]]).To(BeNumerically("~", repeatPenalty))
+		})
+	})
+
+	Describe("retries", func() {
+		job := Job{JobType: ImportPlaylist, Username: "username", Import: &importJob{Name: "playlist", LbzId: EMPTY_UUID}}
+
+		BeforeEach(func() {
+			host.SchedulerMock.Calls = nil
+			host.SchedulerMock.ExpectedCalls = nil
+		})
+
+		It("should schedule a retry at the hinted time", func() {
+			retried := job
+			retried.Retries = 1
+			payload, err := json.Marshal(retried)
+			Expect(err).To(BeNil())
+
+			host.SchedulerMock.On("ScheduleOneTime", int32(91), "retry:"+string(payload), "retry:task-id").Return("1", nil)
+
+			Expect(ScheduleRetry("task-id", job, 90500*time.Millisecond)).To(BeTrue())
+			host.SchedulerMock.AssertCalled(GinkgoT(), "ScheduleOneTime", int32(91), "retry:"+string(payload), "retry:task-id")
+		})
+
+		It("should fall back to queue backoff after too many retries", func() {
+			retried := job
+			retried.Retries = maxScheduledRetries
+
+			Expect(ScheduleRetry("task-id", retried, time.Minute)).To(BeFalse())
+			Expect(host.SchedulerMock.Calls).To(BeEmpty())
+		})
+
+		It("should fall back to queue backoff if scheduling fails", func() {
+			host.SchedulerMock.On("ScheduleOneTime", int32(60), mock.Anything, "retry:task-id").Return("", errors.New("error"))
+			Expect(ScheduleRetry("task-id", job, time.Minute)).To(BeFalse())
+		})
+
+		It("should enqueue a rescheduled job", func() {
+			payload, err := json.Marshal(job)
+			Expect(err).To(BeNil())
+			host.TaskMock.On("Enqueue", "job-queue", payload).Return("", nil)
+
+			Expect(IsRetry("retry:" + string(payload))).To(BeTrue())
+			Expect(IsRetry("daily-cron")).To(BeFalse())
+			Expect(EnqueueRetry("retry:" + string(payload))).To(Succeed())
+			host.TaskMock.AssertCalled(GinkgoT(), "Enqueue", "job-queue", payload)
+		})
+	})
+
+	Describe("versionPreference", func() {
+		ref := types.SongRef{Name: "world.execute(me);", AlbumMBID: "2ff07e32-4f73-4b43-bb54-6f1b5d5e1f0a"}
+		bitDepth := int32(16)
+
+		DescribeTable("compare", func(preference versionPreference, a, b types.Track, expected int) {
+			Expect(preference.compare(ref, &a, &b)).To(Equal(expected))
+		},
+			Entry("no preference", versionPreference{}, types.Track{Suffix: "flac"}, types.Track{}, 0),
+			Entry("preferred library", versionPreference{Library: 2}, types.Track{LibraryID: 2}, types.Track{LibraryID: 1}, -1),
+			Entry("suggested release", versionPreference{Release: true}, types.Track{}, types.Track{MbzAlbumID: ref.AlbumMBID}, 1),
+			Entry("not a compilation", versionPreference{NoCompilations: true}, types.Track{Compilation: true}, types.Track{}, 1),
+			Entry("earliest release", versionPreference{Original: true}, types.Track{ReleaseDate: "2016-02-22"}, types.Track{Year: 2020}, -1),
+			Entry("unknown release date last", versionPreference{Original: true}, types.Track{}, types.Track{Year: 2020}, 1),
+			Entry("lossless", versionPreference{Quality: true}, types.Track{Suffix: "mp3", BitRate: 320}, types.Track{BitDepth: &bitDepth}, 1),
+			Entry("higher bitrate", versionPreference{Quality: true}, types.Track{BitRate: 320}, types.Track{BitRate: 128}, -1),
+			Entry("library before quality", versionPreference{Library: 1, Quality: true}, types.Track{LibraryID: 1}, types.Track{LibraryID: 2, Suffix: "flac"}, -1),
+		)
+
+		It("should only enable the configured preferences", func() {
+			Expect(newVersionPreference(nil, 2)).To(BeNil())
+			Expect(newVersionPreference([]string{"quality", "library"}, 2)).To(Equal(&versionPreference{Library: 2, Quality: true}))
+			Expect(newVersionPreference([]string{"original"}, 2)).To(Equal(&versionPreference{Original: true}))
+		})
+	})
+
+	Describe("freshInWindow", func() {
+		releases := []listenbrainz.FreshRelease{
+			{ReleaseMBID: "a", ReleaseDate: "2026-01-31"},
+			{ReleaseMBID: "b", ReleaseDate: "2026-02-01"},
+			{ReleaseMBID: "c", ReleaseDate: "2026-02-15"},
+			{ReleaseMBID: "b", ReleaseDate: "2026-02-01"},
+			{ReleaseMBID: "d", ReleaseDate: "2026-02-20"},
+			{ReleaseMBID: "e", ReleaseDate: "2026-02-21"},
+		}
+
+		It("should keep releases in the window, newest first", func() {
+			now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.Local)
+			kept := freshInWindow(releases, now, 14, 5)
+			Expect(kept).To(Equal([]listenbrainz.FreshRelease{
+				{ReleaseMBID: "d", ReleaseDate: "2026-02-20"},
+				{ReleaseMBID: "c", ReleaseDate: "2026-02-15"},
+				{ReleaseMBID: "b", ReleaseDate: "2026-02-01"},
+			}))
+		})
+	})
+
+	Describe("editionName", func() {
+		playlist := &listenbrainz.LbzPlaylist{Date: time.Date(2026, 10, 12, 12, 0, 0, 0, time.UTC)}
+
+		It("should name editions of weekly patches after their week", func() {
+			Expect(editionName("Weekly Exploration", "weekly-exploration", playlist)).To(Equal("Weekly Exploration – 2026-W42"))
+		})
+
+		It("should name editions of other patches after their date", func() {
+			Expect(editionName("Daily Jams", "daily-jams", playlist)).To(Equal("Daily Jams – 2026-10-12"))
+		})
+	})
+
+	Describe("planFeedbackSync", func() {
+		starred := time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+
+		songs := []subsonic.Child{
+			{Id: "starred", MusicBrainzId: "a", Starred: &starred},
+			{Id: "low", MusicBrainzId: "b", UserRating: 1},
+			{Id: "unrated", MusicBrainzId: "c"},
+			{Id: "unrated hated", MusicBrainzId: "d"},
+			{Id: "rated hated", MusicBrainzId: "e", UserRating: 4},
+			{Id: "no mbid", Starred: &starred},
+			{Id: "duplicate", MusicBrainzId: "a", Starred: &starred},
+			{Id: "in sync", MusicBrainzId: "f", Starred: &starred},
+		}
+
+		remote := map[string]int{"b": 1, "c": 1, "d": -1, "e": -1, "f": 1}
+
+		It("should push Navidrome feedback and pull the rest", func() {
+			plan := planFeedbackSync(songs, remote, nil, 2)
+			Expect(plan.push).To(Equal([]feedbackChange{{mbid: "a", score: 1}, {mbid: "b", score: -1}}))
+			Expect(plan.star).To(Equal([]string{"unrated"}))
+			Expect(plan.rate).To(Equal([]string{"unrated hated"}))
+			Expect(plan.unstar).To(BeEmpty())
+			Expect(plan.unrate).To(BeEmpty())
+			Expect(plan.synced).To(Equal(map[string]int{"a": 1, "b": -1, "c": 1, "d": -1, "f": 1}))
+		})
+
+		It("should not use ratings when the hated rating is 0", func() {
+			plan := planFeedbackSync(songs, remote, nil, 0)
+			Expect(plan.push).To(Equal([]feedbackChange{{mbid: "a", score: 1}}))
+			Expect(plan.star).To(Equal([]string{"low", "unrated"}))
+			Expect(plan.rate).To(BeEmpty())
+		})
+
+		It("should sync feedback removed since the last sync", func() {
+			synced := map[string]int{"a": 1, "b": -1, "c": 1, "d": -1, "f": 1}
+			plan := planFeedbackSync(songs, map[string]int{"c": 1, "d": -1, "f": 1}, synced, 2)
+			Expect(plan.push).To(Equal([]feedbackChange{{mbid: "c", score: 0}, {mbid: "d", score: 0}}))
+			Expect(plan.unstar).To(Equal([]string{"starred", "duplicate"}))
+			Expect(plan.unrate).To(Equal([]string{"low"}))
+			Expect(plan.star).To(BeEmpty())
+			Expect(plan.rate).To(BeEmpty())
+			Expect(plan.synced).To(Equal(map[string]int{"f": 1}))
+		})
+
+		It("should pull remote feedback when Navidrome feedback was removed and both changed", func() {
+			plan := planFeedbackSync(songs, map[string]int{"c": 1, "f": 1}, map[string]int{"c": -1}, 2)
+			Expect(plan.star).To(Equal([]string{"unrated"}))
+			Expect(plan.push).To(Equal([]feedbackChange{{mbid: "a", score: 1}, {mbid: "b", score: -1}}))
+		})
+	})
+
+	Describe("ClearQueue", func() {
+		It("should successfully clear queue", func() {
+			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(1), nil)
+			ClearQueue()
+			host.TaskMock.AssertCalled(GinkgoT(), "ClearQueue", "job-queue")
+			pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogInfo, "Removed 1 job(s) from task queue")
+		})
+
+		It("should ignore clear error but log in error", func() {
+			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(0), errors.New("Error"))
+			ClearQueue()
+			host.TaskMock.AssertCalled(GinkgoT(), "ClearQueue", "job-queue")
+			pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogError, "Failed to clear task queue: Error")
+		})
+	})
+
+	Describe("dispatching", func() {
+		const lbzEndpoint = "https://api.listenbrainz.org/1"
+		var job Job
+
+		mockSleep := func(d time.Duration) {}
+
+		BeforeEach(func() {
+			job = Job{Username: "username"}
+			oldSleep := sleep.Sleep
+			sleep.Sleep = mockSleep
+
+			pdk.ResetMock()
+			pdk.PDKMock.Calls = nil
+			pdk.PDKMock.ExpectedCalls = nil
+			host.HTTPMock.Calls = nil
+			host.HTTPMock.ExpectedCalls = nil
+			host.TaskMock.Calls = nil
+			host.TaskMock.ExpectedCalls = nil
+			host.MatcherMock.Calls = nil
+			host.MatcherMock.ExpectedCalls = nil
+			host.SubsonicAPIMock.Calls = nil
+			host.SubsonicAPIMock.ExpectedCalls = nil
+			host.KVStoreMock.Calls = nil
+			host.KVStoreMock.ExpectedCalls = nil
+			pdk.PDKMock.On("Log", mock.Anything, mock.Anything).Maybe()
+			mockNoPlaylistState()
+
+			DeferCleanup(func() {
+				sleep.Sleep = oldSleep
+			})
+		})
+
+		setupResponse := func(request host.HTTPRequest, code int, dataPath string, err error, rateLimited bool) {
+			host.HTTPMock.On("Send", request).Return(testdata.MakeLbzResponse(code, dataPath+".json", err, rateLimited))
+		}
+
+		Describe("dispatchSourceFetching", func() {
+			const URL = lbzEndpoint + "/user/test/playlists/createdfor?count=100&offset=0"
+
+			BeforeEach(func() {
+				job.JobType = FetchPatches
+			})
+
+			It("should error if patch is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to dispatch patch fetch without patch")))
+			})
+
+			It("should fail if LBZ response is bad", func() {
+				job.LbzUsername = "a"
+				job.Patch = &patchJob{Sources: []source{{SourcePatch: "daily-jams", PlaylistName: "daily-jams"}}}
+
+				url := lbzEndpoint + "/user/a/playlists/createdfor?count=100&offset=0"
+				request := testdata.MakeLbzRequest(url, "", nil)
+				setupResponse(request, 404, "createdFor.noUser", nil, false)
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.NotFoundError(errors.New("ListenBrainz HTTP Error. Code: 404, Error: Cannot find user: a"))))
+			})
+
+			DescribeTable("should issue a retry if present", func(recoverable error) {
+				job.LbzUsername = "a"
+				job.Patch = &patchJob{Sources: []source{{SourcePatch: "daily-jams", PlaylistName: "daily-jams"}}}
+
+				url := lbzEndpoint + "/user/a/playlists/createdfor?count=100&offset=0"
+				request := testdata.MakeLbzRequest(url, "", nil)
+				setupResponse(request, 0, "", recoverable, false)
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.TransientError(recoverable, 0)))
+			},
+				Entry("connection reset", CONNECTION_RESET),
+				Entry("context deadline", CONTEXT_DEADLINE),
+			)
+
+			It("should error if no playlist found", func() {
+				job.LbzUsername = "test"
+				job.Patch = &patchJob{Sources: []source{{SourcePatch: "daily-jams", PlaylistName: "daily-jams"}}}
+
+				request := testdata.MakeLbzRequest(URL, "", nil)
+				setupResponse(request, 200, "createdFor.success", nil, false)
+				err := job.Dispatch()
+
+				Expect(err).ToNot(BeNil())
+				Expect(err.Retryable).To(BeFalse())
+				Expect(err.Error.Error()).To(Equal("no playlist for ListenBrainz user `test` found with algorithm/source patch `daily-jams`"))
+
+				Expect(host.TaskMock.Calls).To(BeNil())
+			})
+
+			It("should find real playlist, retry on enqueue error", func() {
+				job.LbzUsername = "test"
+				job.Patch = &patchJob{Sources: []source{{SourcePatch: "weekly-exploration", PlaylistName: "daily-jams"}}}
+
+				request := testdata.MakeLbzRequest(URL, "", nil)
+				setupResponse(request, 200, "createdFor.success", nil, false)
+
+				dispatched := Job{
+					JobType:     ImportPlaylist,
+					Username:    "username",
+					LbzUsername: "test",
+					Import: &importJob{
+						Name:  "daily-jams",
+						LbzId: EMPTY_UUID,
+					},
+				}
+
+				dispatchPayload, marshalErr := json.Marshal(dispatched)
+				Expect(marshalErr).To(BeNil())
+				host.TaskMock.On("Enqueue", "job-queue", dispatchPayload).Return("", errors.New("nope"))
+
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.TempError(errors.New("nope"))))
+				Expect(host.TaskMock.Calls).To(HaveLen(1))
+			})
+
+			It("should skip sources whose ListenBrainz playlist was already imported", func() {
+				job.LbzUsername = "test"
+				job.Patch = &patchJob{Sources: []source{{SourcePatch: "weekly-exploration", PlaylistName: "Generated Daily Jams"}}}
+
+				request := testdata.MakeLbzRequest(URL, "", nil)
+				setupResponse(request, 200, "createdFor.success", nil, false)
+
+				state, marshalErr := json.Marshal(playlistState{LbzId: EMPTY_UUID, Updated: time.Date(2026, 02, 23, 12, 0, 0, 0, time.UTC)})
+				Expect(marshalErr).To(BeNil())
+				host.KVStoreMock.ExpectedCalls = nil
+				mockNoEditions()
+				host.KVStoreMock.On("Get", "playlist/username/Generated%20Daily%20Jams").Return(state, true, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.TaskMock.Calls).To(BeEmpty())
+			})
+
+			It("should find real playlist, succeed on shipping task, full job", func() {
+				job.LbzUsername = "test"
+				job.Patch = &patchJob{Sources: []source{
+					{SourcePatch: "weekly-exploration", PlaylistName: "weekly exploration"},
+					{SourcePatch: "daily-jams", PlaylistName: "daily jams"},
+				}}
+				job.LbzToken = "1234"
+				job.Ratings = map[int32]bool{int32(5): true}
+
+				url := lbzEndpoint + "/user/test/playlists/createdfor?count=100&offset=0"
+				request := testdata.MakeLbzRequest(url, "1234", nil)
+				setupResponse(request, 200, "createdFor.success", nil, false)
+
+				dispatched := Job{
+					JobType:     ImportPlaylist,
+					Username:    "username",
+					LbzUsername: "test",
+					LbzToken:    "1234",
+					Ratings:     map[int32]bool{int32(5): true},
+					Import: &importJob{
+						Name:  "weekly exploration",
+						LbzId: EMPTY_UUID,
+					},
+				}
+
+				dispatchPayload, marshalErr := json.Marshal(dispatched)
+				Expect(marshalErr).To(BeNil())
+				host.TaskMock.On("Enqueue", "job-queue", dispatchPayload).Return("", nil)
+
+				err := job.Dispatch()
+				Expect(err).ToNot(BeNil())
+				Expect(err.Retryable).To(BeFalse())
+				Expect(err.Error.Error()).To(Equal("no playlist for ListenBrainz user `test` found with algorithm/source patch `daily-jams`"))
+				Expect(host.TaskMock.Calls).To(HaveLen(1))
+			})
+
+			Context("with several editions", func() {
+				queued := func() map[string]string {
+					imports := map[string]string{}
+					for _, call := range host.TaskMock.Calls {
+						var queuedJob Job
+						Expect(json.Unmarshal(call.Arguments.Get(1).([]byte), &queuedJob)).To(Succeed())
+						imports[queuedJob.Import.Name] = queuedJob.Import.LbzId
+					}
+					return imports
+				}
+
+				BeforeEach(func() {
+					job.LbzUsername = "test"
+					setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "createdFor.editions", nil, false)
+					host.TaskMock.On("Enqueue", "job-queue", mock.Anything).Return("", nil)
+				})
+
+				It("should import the newest playlist of a source", func() {
+					job.Patch = &patchJob{Sources: []source{{SourcePatch: "weekly-exploration", PlaylistName: "Weekly Exploration"}}}
+
+					err := job.Dispatch()
+					Expect(err).To(BeNil())
+					Expect(queued()).To(Equal(map[string]string{"Weekly Exploration": EMPTY_UUID}))
+				})
+
+				It("should import previous editions as dated playlists", func() {
+					job.Patch = &patchJob{Sources: []source{{SourcePatch: "weekly-exploration", PlaylistName: "Weekly Exploration", History: 2}}}
+
+					err := job.Dispatch()
+					Expect(err).To(BeNil())
+					Expect(queued()).To(Equal(map[string]string{
+						"Weekly Exploration":            EMPTY_UUID,
+						"Weekly Exploration – 2026-W08": "00000000-0000-0000-0000-000000000001",
+						"Weekly Exploration – 2026-W07": "00000000-0000-0000-0000-000000000002",
+					}))
+				})
+
+				Context("with previously imported editions", func() {
+					const editionsKey = "editions/username/weekly-exploration/Weekly%20Exploration"
+
+					BeforeEach(func() {
+						job.Patch = &patchJob{Sources: []source{{SourcePatch: "weekly-exploration", PlaylistName: "Weekly Exploration", History: 1}}}
+
+						host.KVStoreMock.ExpectedCalls = nil
+						host.KVStoreMock.On("Get", editionsKey).Return([]byte(`["Weekly Exploration","Weekly Exploration – 2026-W08","Weekly Exploration – 2026-W07","Weekly Exploration – 2026-W06"]`), true, nil)
+						mockNoPlaylistState()
+						testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists.editions")
+					})
+
+					It("should delete the editions out of its history", func() {
+						host.KVStoreMock.On("Delete", "playlist/username/Weekly%20Exploration%20%E2%80%93%202026-W07").Return(nil)
+						host.KVStoreMock.On("Delete", "playlist/username/Weekly%20Exploration%20%E2%80%93%202026-W06").Return(nil)
+						host.KVStoreMock.On("Set", editionsKey, []byte(`["Weekly Exploration","Weekly Exploration – 2026-W08"]`)).Return(nil)
+						testdata.MockSubsonicResponse("username", "deletePlaylist", &url.Values{"id": []string{"Wk06b2Qm4Hx9TzLr1Nc7Pa"}}, "ping.success")
+
+						err := job.Dispatch()
+						Expect(err).To(BeNil())
+						Expect(queued()).To(HaveLen(2))
+						host.SubsonicAPIMock.AssertCalled(GinkgoT(), "Call", "/rest/deletePlaylist?u=username&id=Wk06b2Qm4Hx9TzLr1Nc7Pa")
+						host.KVStoreMock.AssertCalled(GinkgoT(), "Set", editionsKey, mock.Anything)
+					})
+
+					It("should not delete anything on a dry run", func() {
+						job.DryRun = true
+
+						err := job.Dispatch()
+						Expect(err).To(BeNil())
+						Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+						host.KVStoreMock.AssertNotCalled(GinkgoT(), "Set", editionsKey, mock.Anything)
+						pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogInfo, "Dry run: would delete playlist `Weekly Exploration – 2026-W06` for user username, as it is no longer imported")
+					})
+				})
+
+				It("should import every source patch matching a pattern under a templated name", func() {
+					job.Patch = &patchJob{Sources: []source{{SourcePatch: "year-in-music-*", PlaylistName: "{patch} ({date})"}}}
+
+					err := job.Dispatch()
+					Expect(err).To(BeNil())
+					Expect(queued()).To(Equal(map[string]string{
+						"year-in-music-2024 (2025-01-01)": "00000000-0000-0000-0000-000000000024",
+						"year-in-music-2025 (2026-01-01)": "00000000-0000-0000-0000-000000000025",
+					}))
+				})
+			})
+		})
+
+		Describe("dispatchDiscoverPatches", func() {
+			It("should log every available source patch", func() {
+				job.JobType = DiscoverPatches
+				job.LbzUsername = "test"
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists/createdfor?count=100&offset=0", "", nil), 200, "createdFor.editions", nil, false)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogInfo, "Source patch `weekly-exploration` available for ListenBrainz user test: 4 playlist(s), newest `Weekly Exploration for test, week of 2026-02-23 Mon` from 2026-02-23")
+				pdk.PDKMock.AssertCalled(GinkgoT(), "Log", pdk.LogInfo, "Source patch `year-in-music-2025` available for ListenBrainz user test: 1 playlist(s), newest `Top Discoveries of 2025 for test` from 2026-01-01")
+				Expect(host.TaskMock.Calls).To(BeEmpty())
+			})
+		})
+
+		Describe("dispatchGenerate", func() {
+			const URL = lbzEndpoint + "/cf/recommendation/user/test/recording?count=1000"
+
+			isLookup := mock.MatchedBy(func(request host.HTTPRequest) bool {
+				return request.URL == lbzEndpoint+"/metadata/recording"
+			})
+
+			BeforeEach(func() {
+				job.JobType = GenerateJams
+				job.LbzUsername = "test"
+			})
+
+			It("should error if generate job is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to call generate job without generate payload")))
+			})
+
+			It("should share one recommendation fetch between playlists", func() {
+				job.DryRun = true
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.Generated = []generationJob{
+					{Name: "Everything"},
+					{Name: "Five Stars", Ratings: map[int32]bool{5: true}},
+				}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+				host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+				host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1},
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				diffs := map[string]playlistDiff{}
+				host.KVStoreMock.On("Set", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "dryrun/") }), mock.Anything).Run(func(args mock.Arguments) {
+					var diff playlistDiff
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+					diffs[diff.Playlist] = diff
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+
+				Expect(host.HTTPMock.Calls).To(HaveLen(2))
+				Expect(host.MatcherMock.Calls).To(HaveLen(1))
+				Expect(diffs).To(HaveLen(2))
+				Expect(diffs["Everything"].Added).To(Equal([]diffTrack{{ID: "1234", Title: "world.execute(me);", Artist: "Mili"}}))
+				Expect(diffs["Five Stars"].Added).To(BeEmpty())
+				Expect(job.stats.playlist("Five Stars").excluded).To(Equal(1))
+			})
+
+			It("should store a match report of every recommendation", func() {
+				job.DryRun = true
+				job.MatchReports = true
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.Generated = []generationJob{{Name: "Everything"}, {Name: "Five Stars", Ratings: map[int32]bool{5: true}}}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+				host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+				host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1},
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+				host.KVStoreMock.On("Set", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "dryrun/") }), mock.Anything).Return(nil)
+				host.KVStoreMock.On("SetWithTTL", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "missing/") }), mock.Anything, int64(30*24*60*60)).Return(nil)
+
+				reports := map[string]matchReport{}
+				host.KVStoreMock.On("SetWithTTL", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "report/") }), mock.Anything, int64(30*24*60*60)).Run(func(args mock.Arguments) {
+					var report matchReport
+					Expect(json.Unmarshal(args.Get(1).([]byte), &report)).To(Succeed())
+					reports[args.Get(0).(string)] = report
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(reports).To(HaveKey("report/username/Everything"))
+				Expect(reports).To(HaveKey("report/username/Five%20Stars"))
+
+				// Added tracks are only counted
+				Expect(reports["report/username/Everything"].Added).To(Equal(1))
+				Expect(reports["report/username/Everything"].Tracks).To(BeEmpty())
+
+				fiveStars := reports["report/username/Five%20Stars"]
+				Expect(fiveStars.Added).To(Equal(0))
+				Expect(fiveStars.Tracks).To(HaveLen(1))
+				Expect(fiveStars.Tracks[0].Status).To(Equal(trackExcluded))
+				Expect(fiveStars.Tracks[0].SongID).To(Equal("1234"))
+				Expect(fiveStars.Tracks[0].RecordingMBID).ToNot(BeEmpty())
+			})
+
+			It("should exclude tracks placed in the playlist recently", func() {
+				job.DryRun = true
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.Generated = []generationJob{{Name: "Jams", RepeatWindow: 7}}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+				host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+				host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1},
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				history, marshalErr := json.Marshal([]historyDay{{Date: time.Now().AddDate(0, 0, -1).Format(time.DateOnly), SongIds: []string{"1234"}}})
+				Expect(marshalErr).To(BeNil())
+				host.KVStoreMock.On("Get", "history/username/Jams").Return(history, true, nil)
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Jams", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(BeEmpty())
+				Expect(diff.Comment).To(ContainSubstring("Excluded for being in a recent playlist: 1"))
+				host.KVStoreMock.AssertNotCalled(GinkgoT(), "Set", "history/username/Jams", mock.Anything)
+			})
+
+			Describe("forgotten favorites", func() {
+				const topUrl = lbzEndpoint + "/stats/user/test/recordings?count=1000&offset=0&range=all_time"
+
+				var diffs map[string]playlistDiff
+
+				BeforeEach(func() {
+					job.DryRun = true
+					job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+
+					testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+					diffs = map[string]playlistDiff{}
+					host.KVStoreMock.On("Set", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "dryrun/") }), mock.Anything).Run(func(args mock.Arguments) {
+						var diff playlistDiff
+						Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+						diffs[diff.Playlist] = diff
+					}).Return(nil)
+				})
+
+				// A negative number of days means the song was never played in Navidrome
+				DescribeTable("should only keep top recordings played long ago",
+					func(playedDaysAgo int, expected int) {
+						job.Generated = []generationJob{{Name: "Rediscover", TrackAge: 90, Source: sourceForgotten}}
+
+						var playDate *int64
+						if playedDaysAgo >= 0 {
+							played := time.Now().AddDate(0, 0, -playedDaysAgo).Unix()
+							playDate = &played
+						}
+
+						setupResponse(testdata.MakeLbzRequest(topUrl, "", nil), 200, "topRecordings.success", nil, false)
+						host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+							{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1, PlayDate: playDate},
+						}, nil)
+
+						err := job.Dispatch()
+						Expect(err).To(BeNil())
+						Expect(diffs["Rediscover"].Added).To(HaveLen(expected))
+						Expect(diffs["Rediscover"].Comment).To(ContainSubstring("with 1 all-time top recordings generated on"))
+						// Recommendations are not fetched
+						Expect(host.HTTPMock.Calls).To(HaveLen(1))
+					},
+					Entry("played a year ago", 365, 1),
+					Entry("played last week", 7, 0),
+					Entry("never played", -1, 0),
+				)
+
+				It("should still generate recommendation playlists if statistics are missing", func() {
+					job.Generated = []generationJob{{Name: "Rediscover", TrackAge: 90, Source: sourceForgotten}, {Name: "Jams"}}
+
+					setupResponse(testdata.MakeLbzRequest(topUrl, "", nil), 204, "topRecordings.notCalculated", nil, false)
+					setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+					host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+					host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+						{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1},
+					}, nil)
+
+					err := job.Dispatch()
+					Expect(err).To(Equal(&retry.Error{Error: errors.Join(errors.New("ListenBrainz has not calculated all_time statistics for user test yet"))}))
+					Expect(diffs).To(HaveKey("Jams"))
+					Expect(job.stats.errors).To(HaveKey("Rediscover"))
+				})
+			})
+
+			It("should exclude tracks listened to recently on ListenBrainz", func() {
+				job.DryRun = true
+				job.RecentListenLimit = 1000
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.Generated = []generationJob{{Name: "Jams", TrackAge: 36500}, {Name: "Anything Goes"}}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/listens?count=1000", "", nil), 200, "listens.page1", nil, false)
+				host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+				// Never played in Navidrome
+				host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1},
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				diffs := map[string]playlistDiff{}
+				host.KVStoreMock.On("Set", mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "dryrun/") }), mock.Anything).Run(func(args mock.Arguments) {
+					var diff playlistDiff
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+					diffs[diff.Playlist] = diff
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diffs["Jams"].Added).To(BeEmpty())
+				Expect(diffs["Jams"].Comment).To(ContainSubstring("Excluded for being recent: 1"))
+				Expect(diffs["Anything Goes"].Added).To(HaveLen(1))
+			})
+
+			It("should exclude recordings hated on ListenBrainz", func() {
+				job.DryRun = true
+				job.ExcludeHated = true
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.Generated = []generationJob{{Name: "Jams"}}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/feedback/user/test/get-feedback?count=1000&offset=0", "", nil), 200, "feedback.hated", nil, false)
+				host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+				host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 1},
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Jams", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(BeEmpty())
+				Expect(diff.Comment).To(ContainSubstring("Excluded as hated on ListenBrainz: world.execute(me);"))
+				Expect(job.stats.playlist("Jams").excluded).To(Equal(1))
+				Expect(job.stats.playlist("Jams").matched).To(Equal(0))
+			})
+
+			It("should only list overrides and substitutes picked for the playlist", func() {
+				job.DryRun = true
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 5: true}
+				job.Generated = []generationJob{{Name: "Jams"}}
+				job.Overrides = map[string]string{"9980309d-3480-4e7e-89ce-fce971a452be": "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getRecommendations.single", nil, false)
+				host.HTTPMock.On("Send", isLookup).Return(testdata.MakeLbzResponse(200, "lookupMetadata.success.json", nil, false))
+				testdata.MockSubsonicResponse("username", "getSong", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}}, "getSong")
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Jams", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(BeEmpty())
+				Expect(diff.Comment).To(ContainSubstring("Excluded by rating rules: Rubber Human"))
+				Expect(diff.Comment).NotTo(ContainSubstring("Matched by override"))
+			})
+		})
+
+		Describe("dispatchTopTracks", func() {
+			const URL = lbzEndpoint + "/stats/user/test/recordings?count=50&offset=0&range=month"
+
+			BeforeEach(func() {
+				job.JobType = TopTracks
+				job.LbzUsername = "test"
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+			})
+
+			It("should error if top job is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to call top tracks job without top payload")))
+			})
+
+			It("should write the matched top recordings, in order", func() {
+				job.Top = &topJob{Name: "My Top 50 This Month", Range: "month"}
+				job.DryRun = true
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "topRecordings.success", nil, false)
+				host.MatcherMock.On("MatchSongs", []types.SongRef{{
+					Name:      "world.execute(me);",
+					MBID:      "9980309d-3480-4e7e-89ce-fce971a452be",
+					Album:     "Miracle Milk",
+					AlbumMBID: "2ff07e32-4f73-4b43-bb54-6f1b5d5e1f0a",
+					Artists:   []types.ArtistRef{{Name: "Mili", MBID: "d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"}},
+				}}, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili"},
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/My%20Top%2050%20This%20Month", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(Equal([]diffTrack{{ID: "1234", Title: "world.execute(me);", Artist: "Mili"}}))
+				Expect(diff.Comment).To(Equal("Top 50 recordings (month) from ListenBrainz statistics\nUpdated on: 2026-02-23T11:19:15Z"))
+			})
+
+			It("should not match or update a playlist whose statistics are unchanged", func() {
+				job.Top = &topJob{Name: "Generated Daily Jams", Range: "month", Size: 50}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "topRecordings.success", nil, false)
+
+				state, marshalErr := json.Marshal(playlistState{LbzId: "top/month/50", Updated: time.Unix(1771845555, 0)})
+				Expect(marshalErr).To(BeNil())
+				host.KVStoreMock.ExpectedCalls = nil
+				host.KVStoreMock.On("Get", "playlist/username/Generated%20Daily%20Jams").Return(state, true, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+				Expect(job.stats.skipped).To(HaveKey("Generated Daily Jams"))
+			})
+		})
+
+		Describe("dispatchFreshReleases", func() {
+			search := func(name string) *url.Values {
+				return &url.Values{
+					"query":       []string{name},
+					"artistCount": []string{"0"},
+					"albumCount":  []string{"20"},
+					"songCount":   []string{"0"},
+				}
+			}
+
+			BeforeEach(func() {
+				job.JobType = FreshReleases
+				job.LbzUsername = "test"
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+			})
+
+			It("should error if fresh job is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to call fresh releases job without fresh payload")))
+			})
+
+			It("should add the releases in the library, newest first", func() {
+				job.Fresh = &freshJob{Name: "Fresh Releases", Past: 36500, Future: 36500}
+				job.DryRun = true
+
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/fresh_releases?sort=release_date&past=true&future=true", "", nil), 200, "freshReleases.success", nil, false)
+				testdata.MockSubsonicResponse("username", "search3", search("Night Plains"), "search3.empty")
+				testdata.MockSubsonicResponse("username", "search3", search("Miracle Milk"), "search3.albums")
+				testdata.MockSubsonicResponse("username", "getAlbum", &url.Values{"id": []string{"04A1833aXINiHFfq8i1eie"}}, "getAlbum")
+				testdata.MockSubsonicResponse("username", "search3", search("Hue"), "search3.empty")
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Fresh%20Releases", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(Equal([]diffTrack{
+					{ID: "cd020be4e71f3f9a1856ebc89741f4d9", Title: "world.execute(me);", Artist: "Mili"},
+					{ID: "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98", Title: "Rubber Human", Artist: "Mili"},
+				}))
+				Expect(diff.Comment).To(ContainSubstring("\nReleases: Miracle Milk by Mili (2026-02-10)\nReleases not in library: 2"))
+				Expect(job.stats.playlist("Fresh Releases").missing).To(Equal(2))
+			})
+		})
+
+		Describe("dispatchMirror", func() {
+			const (
+				ROAD_TRIP = "11111111-1111-1111-1111-111111111111"
+				GONE      = "99999999-9999-9999-9999-999999999999"
+			)
+
+			mockListing := func() {
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=100&offset=0", "", nil), 200, "userPlaylists.page1", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=100&offset=2", "", nil), 200, "userPlaylists.page2", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists/collaborator?count=100&offset=0", "", nil), 200, "collaboratorPlaylists.success", nil, false)
+			}
+
+			mockMirrorState := func(state mirrorState) *mirrorState {
+				if state != nil {
+					payload, err := json.Marshal(state)
+					Expect(err).To(BeNil())
+					host.KVStoreMock.On("Get", "mirror/username").Return(payload, true, nil)
+				} else {
+					host.KVStoreMock.On("Get", "mirror/username").Return([]byte(nil), false, nil)
+				}
+
+				saved := &mirrorState{}
+				host.KVStoreMock.On("Set", "mirror/username", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), saved)).To(Succeed())
+				}).Return(nil).Maybe()
+				return saved
+			}
+
+			queued := func() []string {
+				names := []string{}
+				for _, call := range host.TaskMock.Calls {
+					var queuedJob Job
+					Expect(json.Unmarshal(call.Arguments.Get(1).([]byte), &queuedJob)).To(Succeed())
+					Expect(queuedJob.JobType).To(Equal(ImportPlaylist))
+					names = append(names, queuedJob.Import.Name)
+				}
+				return names
+			}
+
+			BeforeEach(func() {
+				job.JobType = Mirror
+				job.LbzUsername = "test"
+				host.TaskMock.On("Enqueue", "job-queue", mock.Anything).Return("", nil)
+			})
+
+			It("should error if mirror job is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to call mirror job without mirror payload")))
+			})
+
+			It("should queue an import of every owned and collaborative playlist", func() {
+				job.Mirror = &mirrorJob{NameTemplate: "LB: {title}"}
+
+				mockListing()
+				saved := mockMirrorState(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(queued()).To(Equal([]string{"LB: Road Trip", "LB: Rainy Days", "LB: Late Night", "LB: Shared Mix"}))
+				Expect(*saved).To(Equal(mirrorState{
+					ROAD_TRIP:                              "LB: Road Trip",
+					"22222222-2222-2222-2222-222222222222": "LB: Rainy Days",
+					"33333333-3333-3333-3333-333333333333": "LB: Late Night",
+					"44444444-4444-4444-4444-444444444444": "LB: Shared Mix",
+				}))
+			})
+
+			It("should rename the local playlist when renamed upstream", func() {
+				job.Mirror = &mirrorJob{}
+
+				mockListing()
+				saved := mockMirrorState(mirrorState{ROAD_TRIP: "Generated Daily Jams"})
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+				testdata.MockSubsonicResponse("username", "updatePlaylist", &url.Values{"playlistId": []string{"C8hOrsjiVnnHZTXqxLs57t"}, "name": []string{"Road Trip"}}, "ping.success")
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(2))
+				Expect(queued()).To(ContainElement("Road Trip"))
+				Expect((*saved)[ROAD_TRIP]).To(Equal("Road Trip"))
+				Expect(job.stats.playlist("Road Trip").message).To(Equal("renamed from `Generated Daily Jams`"))
+			})
+
+			It("should delete the local playlist when deleted upstream, if enabled", func() {
+				job.Mirror = &mirrorJob{Delete: true}
+
+				mockListing()
+				saved := mockMirrorState(mirrorState{GONE: "Generated Daily Jams"})
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+				testdata.MockSubsonicResponse("username", "deletePlaylist", &url.Values{"id": []string{"C8hOrsjiVnnHZTXqxLs57t"}}, "ping.success")
+				host.KVStoreMock.On("Delete", "playlist/username/Generated%20Daily%20Jams").Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				host.SubsonicAPIMock.AssertCalled(GinkgoT(), "Call", "/rest/deletePlaylist?u=username&id=C8hOrsjiVnnHZTXqxLs57t")
+				Expect(*saved).ToNot(HaveKey(GONE))
+				Expect(job.stats.playlist("Generated Daily Jams").message).To(Equal("deleted on ListenBrainz, removed"))
+			})
+
+			It("should keep the local playlist when deleted upstream by default", func() {
+				job.Mirror = &mirrorJob{}
+
+				mockListing()
+				saved := mockMirrorState(mirrorState{GONE: "Generated Daily Jams"})
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+				Expect(*saved).ToNot(HaveKey(GONE))
+				Expect(job.stats.playlist("Generated Daily Jams").message).To(Equal("deleted on ListenBrainz, no longer mirrored"))
+			})
+
+			It("should not rename, delete or remember anything on a dry run", func() {
+				job.Mirror = &mirrorJob{Delete: true}
+				job.DryRun = true
+
+				mockListing()
+				mockMirrorState(mirrorState{ROAD_TRIP: "Generated Daily Jams", GONE: "Gone"})
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(1))
+				host.KVStoreMock.AssertNotCalled(GinkgoT(), "Set", "mirror/username", mock.Anything)
+				Expect(queued()).To(HaveLen(4))
+			})
+
+			It("should only mirror the first of playlists with the same name", func() {
+				job.Mirror = &mirrorJob{}
+
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=100&offset=0", "", nil), 200, "userPlaylists.page1", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=100&offset=2", "", nil), 200, "userPlaylists.page2", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists/collaborator?count=100&offset=0", "", nil), 200, "collaboratorPlaylists.sameTitle", nil, false)
+				saved := mockMirrorState(nil)
+
+				err := job.Dispatch()
+				Expect(err).ToNot(BeNil())
+				Expect(err.Retryable).To(BeFalse())
+				Expect(err.Error.Error()).To(Equal("ListenBrainz playlists 11111111-1111-1111-1111-111111111111 and 55555555-5555-5555-5555-555555555555 would both be mirrored as `Road Trip`, only mirroring the first"))
+				Expect(queued()).To(Equal([]string{"Road Trip", "Rainy Days", "Late Night"}))
+				Expect(*saved).To(HaveKeyWithValue(ROAD_TRIP, "Road Trip"))
+			})
+
+			It("should skip playlists named like another playlist of the user, without removing it", func() {
+				job.Mirror = &mirrorJob{Delete: true, Reserved: []string{"Rainy Days", "Generated Daily Jams"}}
+
+				mockListing()
+				saved := mockMirrorState(mirrorState{GONE: "Generated Daily Jams"})
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+				Expect(queued()).To(Equal([]string{"Road Trip", "Late Night", "Shared Mix"}))
+				Expect(*saved).ToNot(HaveKey("22222222-2222-2222-2222-222222222222"))
+				Expect(*saved).ToNot(HaveKey(GONE))
+				Expect(job.stats.skipped).To(HaveKeyWithValue("Rainy Days", "name used by another playlist of this user"))
+			})
+		})
+
+		Describe("dispatchRadio", func() {
+			const radioUrl = lbzEndpoint + "/explore/lb-radio?prompt=artist%3A%28Mili%29&mode=easy"
+
+			BeforeEach(func() {
+				job.JobType = Radio
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+			})
+
+			It("should error if radio job is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to call radio job without radio payload")))
+			})
+
+			It("should error if the prompt is invalid", func() {
+				job.Radio = &radioJob{Name: "Mili Radio", Prompt: "artist:(Mili)", Mode: "easy"}
+
+				setupResponse(testdata.MakeLbzRequest(radioUrl, "", nil), 400, "radio.badPrompt", nil, false)
+
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("ListenBrainz HTTP Error. Code: 400, Error: The prompt could not be parsed: unknown entity `foo`")))
+				Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+			})
+
+			It("should match the generated tracks like an imported playlist", func() {
+				job.Radio = &radioJob{Name: "Mili Radio", Prompt: "artist:(Mili)", Mode: "easy"}
+				job.DryRun = true
+
+				setupResponse(testdata.MakeLbzRequest(radioUrl, "", nil), 200, "radio.success", nil, false)
+				host.MatcherMock.On("MatchSongs", mock.Anything, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", LibraryID: 1},
+					nil,
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Mili%20Radio", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(Equal([]diffTrack{{ID: "1234", Title: "world.execute(me);", Artist: "Mili"}}))
+				Expect(diff.Comment).To(HavePrefix("Generated by LB Radio from prompt `artist:(Mili)` (mode easy)\nUpdated on: "))
+				Expect(diff.Comment).To(HaveSuffix("\nTracks not matched イザナ平原/夜 by ACE(工藤ともり、CHiCO)"))
+				Expect(job.stats.playlist("Mili Radio").missing).To(Equal(1))
+			})
+		})
+
+		Describe("dispatchFeedbackSync", func() {
+			const feedbackUrl = lbzEndpoint + "/feedback/user/test/get-feedback?count=1000&offset=0"
+
+			library := &url.Values{
+				"query":       []string{""},
+				"artistCount": []string{"0"},
+				"albumCount":  []string{"0"},
+				"songCount":   []string{"500"},
+				"songOffset":  []string{"0"},
+			}
+
+			BeforeEach(func() {
+				job.JobType = SyncFeedback
+				job.LbzUsername = "test"
+			})
+
+			It("should error if feedback job is missing", func() {
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.FatalError("attempting to sync feedback without feedback payload")))
+			})
+
+			It("should push hated ratings and pull hated recordings", func() {
+				job.Feedback = &feedbackSyncJob{HateRating: 4}
+
+				testdata.MockSubsonicResponse("username", "search3", library, "search3")
+				setupResponse(testdata.MakeLbzRequest(feedbackUrl, "", nil), 200, "feedback.hated", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/feedback/recording-feedback", "", []byte(`{"recording_mbid":"3f1e0c55-4f0c-4f6a-9b3b-2b8e3f0b6c11","score":-1}`)), 200, "submitFeedback.success", nil, false)
+				testdata.MockSubsonicResponse("username", "setRating", &url.Values{"id": []string{"cd020be4e71f3f9a1856ebc89741f4d9"}, "rating": []string{"1"}}, "ping.success")
+				host.KVStoreMock.On("Get", "feedback/username").Return([]byte(nil), false, nil)
+				host.KVStoreMock.On("Set", "feedback/username", []byte(`{"3f1e0c55-4f0c-4f6a-9b3b-2b8e3f0b6c11":-1,"9980309d-3480-4e7e-89ce-fce971a452be":-1}`)).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.HTTPMock.Calls).To(HaveLen(2))
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(2))
+				host.KVStoreMock.AssertCalled(GinkgoT(), "Set", "feedback/username", mock.Anything)
+			})
+
+			It("should sync feedback removed since the last sync", func() {
+				job.Feedback = &feedbackSyncJob{HateRating: 4}
+
+				testdata.MockSubsonicResponse("username", "search3", library, "search3")
+				setupResponse(testdata.MakeLbzRequest(feedbackUrl, "", nil), 200, "feedback.hated", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/feedback/recording-feedback", "", []byte(`{"recording_mbid":"9980309d-3480-4e7e-89ce-fce971a452be","score":0}`)), 200, "submitFeedback.success", nil, false)
+				testdata.MockSubsonicResponse("username", "setRating", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}, "rating": []string{"0"}}, "ping.success")
+				host.KVStoreMock.On("Get", "feedback/username").Return([]byte(`{"3f1e0c55-4f0c-4f6a-9b3b-2b8e3f0b6c11":-1,"9980309d-3480-4e7e-89ce-fce971a452be":-1}`), true, nil)
+				host.KVStoreMock.On("Set", "feedback/username", []byte(`{}`)).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.HTTPMock.Calls).To(HaveLen(2))
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(2))
+				host.KVStoreMock.AssertCalled(GinkgoT(), "Set", "feedback/username", []byte(`{}`))
+			})
+
+			It("should not change anything on a dry run", func() {
+				job.Feedback = &feedbackSyncJob{HateRating: 4}
+				job.DryRun = true
+
+				testdata.MockSubsonicResponse("username", "search3", library, "search3")
+				host.KVStoreMock.On("Get", "feedback/username").Return([]byte(nil), false, nil)
+				setupResponse(testdata.MakeLbzRequest(feedbackUrl, "", nil), 200, "feedback.hated", nil, false)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.HTTPMock.Calls).To(HaveLen(1))
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(1))
+			})
+		})
+
+		Describe("filterMatches", func() {
+			var (
+				tracks = []types.SongRef{
+					{Name: "world.execute(me);", MBID: "9980309d-3480-4e7e-89ce-fce971a452be"},
+					{Name: "world.execute(me); (live)", MBID: "11111111-1111-1111-1111-111111111111"},
+					{Name: "Rubber Human", MBID: "22222222-2222-2222-2222-222222222222"},
+					{Name: "Nine Point Eight", MBID: "33333333-3333-3333-3333-333333333333"},
+					{Name: "イザナ平原/夜", MBID: "7e4bb014-51d5-4943-adb1-683e066a5220"},
+					{Name: "Summoning 101", MBID: "44444444-4444-4444-4444-444444444444"},
+				}
+				creators = []string{"Mili", "Mili", "Mili", "Mili", "ACE", "Mili"}
+				song     = &types.Track{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 5}
+				matches  = []*types.Track{
+					song,
+					{ID: "1234", Title: "world.execute(me);", Artist: "Mili", Rating: 5},
+					{ID: "5678", Title: "Rubber Human", Artist: "Mili", Rating: 1},
+					{ID: "9012", Title: "Nine Point Eight", Artist: "Mili", Rating: 5},
+					nil,
+					nil,
+				}
+				kinds = map[int]matchKind{1: matchFallback, 5: matchNever}
+			)
+
+			BeforeEach(func() {
+				job.ExcludeHated = true
+			})
+
+			It("should keep the first of songs matched several times, and report the others as duplicates", func() {
+				feedback := map[string]int{"33333333-3333-3333-3333-333333333333": listenbrainz.FeedbackHated}
+
+				matched := job.filterMatches(tracks, creators, matches, kinds, feedback, map[int32]bool{5: true})
+				Expect(matched.songs).To(Equal([]*types.Track{song}))
+				Expect(matched.origins).To(Equal(map[*types.Track]int{song: 0}))
+
+				statuses := []matchStatus{}
+				for _, track := range matched.report {
+					statuses = append(statuses, track.Status)
+				}
+				Expect(statuses).To(Equal([]matchStatus{trackExact, trackDuplicate, trackExcluded, trackHated, trackMissing, trackNeverMatched}))
+
+				Expect(matched.comment("header")).To(Equal("header" +
+					"\nTracks not matched イザナ平原/夜 by ACE" +
+					"\nTracks excluded by rating rule: Rubber Human by Mili" +
+					"\nTracks excluded as hated on ListenBrainz: Nine Point Eight by Mili" +
+					"\nNever matched by override: Summoning 101 by Mili"))
+			})
+
+			It("should label tracks by title alone without creators", func() {
+				matched := job.filterMatches(tracks, nil, matches, kinds, nil, map[int32]bool{1: true, 5: true})
+				Expect(matched.missing).To(Equal([]string{"イザナ平原/夜"}))
+				Expect(matched.excluded).To(BeEmpty())
+				Expect(matched.notes(matched.songs)).To(Equal("\nNever matched by override: Summoning 101"))
+				Expect(matched.report[4].Creator).To(BeEmpty())
+			})
+		})
+
+		Describe("matchSongs", func() {
+			var (
+				known   = types.SongRef{Name: "world.execute(me);", MBID: "9980309d-3480-4e7e-89ce-fce971a452be"}
+				missing = types.SongRef{Name: "イザナ平原/夜", MBID: "7e4bb014-51d5-4943-adb1-683e066a5220"}
+				match   = &types.Track{ID: "1234", Title: "world.execute(me);", Artist: "Mili"}
+				options = host.MatchOptions{Username: "username"}
+			)
+
+			mockCache := func(cache *matchCache) {
+				if cache == nil {
+					host.KVStoreMock.On("Get", "matches/username").Return([]byte(nil), false, nil)
+					return
+				}
+
+				data, err := json.Marshal(cache)
+				Expect(err).To(BeNil())
+				host.KVStoreMock.On("Get", "matches/username").Return(data, true, nil)
+			}
+
+			captureCache := func() *matchCache {
+				saved := &matchCache{}
+				host.KVStoreMock.On("SetWithTTL", "matches/username", mock.Anything, int64(24*60*60)).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), saved)).To(Succeed())
+				}).Return(nil)
+				return saved
+			}
+
+			BeforeEach(func() {
+				job.MatchCacheHours = 24
+				host.LibraryMock.Calls = nil
+				host.LibraryMock.ExpectedCalls = nil
+				host.LibraryMock.On("GetAllLibraries").Return([]host.Library{{ID: 1, LastScanAt: 100}, {ID: 2, LastScanAt: 50}}, nil)
+			})
+
+			It("should match directly when the cache is disabled", func() {
+				job.MatchCacheHours = 0
+				host.MatcherMock.On("MatchSongs", []types.SongRef{known, missing}, options).Return([]*types.Track{match, nil}, nil)
+
+				matches, err := job.matchSongs([]types.SongRef{known, missing})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match, nil}))
+				Expect(host.KVStoreMock.Calls).To(BeEmpty())
+				Expect(host.LibraryMock.Calls).To(BeEmpty())
+			})
+
+			It("should cache matches and misses", func() {
+				mockCache(nil)
+				saved := captureCache()
+				host.MatcherMock.On("MatchSongs", []types.SongRef{known, missing}, options).Return([]*types.Track{match, nil}, nil)
+
+				matches, err := job.matchSongs([]types.SongRef{known, missing})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match, nil}))
+				Expect(saved.ScannedAt).To(Equal(int64(100)))
+				Expect(saved.Entries).To(HaveLen(2))
+				Expect(saved.Entries[known.MBID].SongID).To(Equal("1234"))
+				Expect(saved.Entries[missing.MBID].SongID).To(BeEmpty())
+			})
+
+			It("should fetch cached matches by ID without the matcher and skip cached misses", func() {
+				now := time.Now().Unix()
+				mockCache(&matchCache{ScannedAt: 100, Entries: map[string]matchEntry{
+					known.MBID:   {SongID: "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98", CachedAt: now - 60},
+					missing.MBID: {CachedAt: now - 60},
+				}})
+				saved := captureCache()
+				testdata.MockSubsonicResponse("username", "getSong", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}}, "getSong")
+
+				matches, err := job.matchSongs([]types.SongRef{known, missing})
+				Expect(err).To(BeNil())
+				Expect(matches).To(HaveLen(2))
+				Expect(matches[0].ID).To(Equal("6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"))
+				Expect(matches[0].Rating).To(Equal(int32(4)))
+				Expect(matches[1]).To(BeNil())
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+				Expect(saved.Entries[known.MBID]).To(Equal(matchEntry{SongID: "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98", CachedAt: now - 60}))
+				Expect(saved.Entries[missing.MBID]).To(Equal(matchEntry{CachedAt: now - 60}))
+			})
+
+			It("should match again a cached song that can no longer be fetched", func() {
+				mockCache(&matchCache{ScannedAt: 100, Entries: map[string]matchEntry{
+					known.MBID: {SongID: "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98", CachedAt: time.Now().Unix()},
+				}})
+				saved := captureCache()
+				testdata.MockSubsonicResponse("username", "getSong", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}}, "error")
+				host.MatcherMock.On("MatchSongs", []types.SongRef{known}, options).Return([]*types.Track{match}, nil)
+
+				matches, err := job.matchSongs([]types.SongRef{known})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match}))
+				Expect(saved.Entries[known.MBID].SongID).To(Equal("1234"))
+			})
+
+			It("should not call the matcher when every track is cached as missing", func() {
+				mockCache(&matchCache{ScannedAt: 100, Entries: map[string]matchEntry{
+					missing.MBID: {CachedAt: time.Now().Unix()},
+				}})
+				captureCache()
+
+				matches, err := job.matchSongs([]types.SongRef{missing})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{nil}))
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+			})
+
+			It("should rematch expired entries", func() {
+				mockCache(&matchCache{ScannedAt: 100, Entries: map[string]matchEntry{
+					missing.MBID: {CachedAt: time.Now().Add(-25 * time.Hour).Unix()},
+				}})
+				saved := captureCache()
+				host.MatcherMock.On("MatchSongs", []types.SongRef{missing}, options).Return([]*types.Track{match}, nil)
+
+				matches, err := job.matchSongs([]types.SongRef{missing})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match}))
+				Expect(saved.Entries[missing.MBID].SongID).To(Equal("1234"))
+			})
+
+			It("should discard the cache after a library scan", func() {
+				mockCache(&matchCache{ScannedAt: 90, Entries: map[string]matchEntry{
+					known.MBID:   {SongID: "1234", CachedAt: time.Now().Unix()},
+					missing.MBID: {CachedAt: time.Now().Unix()},
+				}})
+				saved := captureCache()
+				host.MatcherMock.On("MatchSongs", []types.SongRef{known, missing}, options).Return([]*types.Track{match, match}, nil)
+
+				matches, err := job.matchSongs([]types.SongRef{known, missing})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match, match}))
+				Expect(saved.ScannedAt).To(Equal(int64(100)))
+				Expect(saved.Entries[missing.MBID].SongID).To(Equal("1234"))
+			})
+
+			Context("with a version preference", func() {
+				var (
+					mp3      = &types.Track{ID: "cd020be4e71f3f9a1856ebc89741f4d9", Title: "world.execute(me);", Artist: "Mili", Suffix: "mp3", BitRate: 287}
+					lossless = &types.Track{ID: "a7c3e5d1b9f24e6c8d0a2b4f6e8c1d3a", Title: "world.execute(me);", Artist: "Mili", Suffix: "flac", BitRate: 1024}
+				)
+
+				BeforeEach(func() {
+					job.Preference = &versionPreference{Quality: true}
+				})
+
+				It("should cache the preferred version", func() {
+					mockCache(&matchCache{ScannedAt: 100, Entries: map[string]matchEntry{
+						known.MBID: {SongID: mp3.ID, CachedAt: time.Now().Unix()},
+					}})
+					saved := captureCache()
+
+					host.MatcherMock.On("MatchSongs", []types.SongRef{known}, options).Return([]*types.Track{mp3}, nil)
+					testdata.MockSubsonicResponse("username", "search3", &url.Values{
+						"query":       []string{"world.execute(me);"},
+						"artistCount": []string{"0"},
+						"albumCount":  []string{"0"},
+						"songCount":   []string{"20"},
+					}, "search3.duplicates")
+					host.MatcherMock.On("MatchSongs", []types.SongRef{{ID: lossless.ID, Name: lossless.Title, MBID: known.MBID}}, options).Return([]*types.Track{lossless}, nil)
+
+					matches, err := job.matchSongs([]types.SongRef{known})
+					Expect(err).To(BeNil())
+					Expect(matches).To(Equal([]*types.Track{lossless}))
+					Expect(saved.Preference).To(Equal(job.Preference))
+					Expect(saved.Entries[known.MBID].SongID).To(Equal(lossless.ID))
+				})
+
+				It("should not look for other versions of cached matches", func() {
+					now := time.Now().Unix()
+					mockCache(&matchCache{ScannedAt: 100, Preference: &versionPreference{Quality: true}, Entries: map[string]matchEntry{
+						known.MBID: {SongID: "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98", CachedAt: now},
+					}})
+					captureCache()
+					testdata.MockSubsonicResponse("username", "getSong", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}}, "getSong")
+
+					matches, err := job.matchSongs([]types.SongRef{known})
+					Expect(err).To(BeNil())
+					Expect(matches).To(HaveLen(1))
+					Expect(matches[0].ID).To(Equal("6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"))
+					Expect(host.MatcherMock.Calls).To(BeEmpty())
+					Expect(host.SubsonicAPIMock.Calls).To(HaveLen(1))
+				})
+			})
+
+			It("should match without the cache if libraries cannot be listed", func() {
+				host.LibraryMock.ExpectedCalls = nil
+				host.LibraryMock.On("GetAllLibraries").Return([]host.Library(nil), errors.New("no libraries"))
+				host.MatcherMock.On("MatchSongs", []types.SongRef{known}, options).Return([]*types.Track{match}, nil)
+
+				matches, err := job.matchSongs([]types.SongRef{known})
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match}))
+				Expect(host.KVStoreMock.Calls).To(BeEmpty())
+			})
+		})
+
+		Describe("dispatchRematch", func() {
+			const key = "gaps/username/Generated%20Daily%20Jams"
+			var (
+				existing = "cd020be4e71f3f9a1856ebc89741f4d9"
+				first    = types.SongRef{Name: "イザナ平原/夜", MBID: "7e4bb014-51d5-4943-adb1-683e066a5220"}
+				second   = types.SongRef{Name: "Rubber Human", MBID: "6b3c9c1a-5d2e-4f3a-9b7c-8e1d2f3a4b5c"}
+				match    = &types.Track{ID: "5678", Title: "イザナ平原/夜", Artist: "Mili"}
+				options  = host.MatchOptions{Username: "username"}
+				gaps     playlistGaps
+			)
+
+			mockGaps := func() {
+				data, err := json.Marshal(gaps)
+				Expect(err).To(BeNil())
+				host.KVStoreMock.On("List", "gaps/username/").Return([]string{key}, nil)
+				host.KVStoreMock.On("Get", key).Return(data, true, nil)
+			}
+
+			BeforeEach(func() {
+				job.JobType = Rematch
+				job.RematchMissing = true
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				gaps = playlistGaps{
+					ScannedAt: 50,
+					Comment:   "Header\nTracks not matched イザナ平原/夜 by Mili, Rubber Human by Mili",
+					Songs:     []string{existing},
+					Tracks: []gapTrack{
+						{Index: 0, Label: "イザナ平原/夜 by Mili", Ref: first},
+						{Index: 1, Label: "Rubber Human by Mili", Ref: second},
+					},
+				}
+
+				host.LibraryMock.Calls = nil
+				host.LibraryMock.ExpectedCalls = nil
+				host.LibraryMock.On("GetAllLibraries").Return([]host.Library{{ID: 1, LastScanAt: 100}}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+				testdata.MockSubsonicResponse("username", "getPlaylist", &url.Values{"id": []string{"C8hOrsjiVnnHZTXqxLs57t"}}, "createPlaylist")
+			})
+
+			It("should add found tracks at their original position and remember the rest", func() {
+				mockGaps()
+				host.MatcherMock.On("MatchSongs", []types.SongRef{first, second}, options).Return([]*types.Track{match, nil}, nil)
+
+				testdata.MockSubsonicResponse("username", "createPlaylist", &url.Values{
+					"playlistId": []string{"C8hOrsjiVnnHZTXqxLs57t"},
+					"songId":     []string{match.ID, existing},
+				}, "createPlaylist")
+				testdata.MockSubsonicResponse("username", "updatePlaylist", &url.Values{
+					"comment":    []string{"Header\nTracks not matched Rubber Human by Mili\nMatched after a library scan: イザナ平原/夜 by Mili"},
+					"playlistId": []string{"C8hOrsjiVnnHZTXqxLs57t"},
+				}, "ping.success")
+
+				var saved playlistGaps
+				host.KVStoreMock.On("Set", key, mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &saved)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(saved.ScannedAt).To(Equal(int64(100)))
+				Expect(saved.Songs).To(Equal([]string{match.ID, existing}))
+				Expect(saved.Tracks).To(Equal([]gapTrack{{Index: 2, Label: "Rubber Human by Mili", Ref: second}}))
+			})
+
+			It("should not rematch before a new library scan", func() {
+				gaps.ScannedAt = 100
+				mockGaps()
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+				Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+			})
+
+			It("should only remember the scan if no missing track was found", func() {
+				mockGaps()
+				host.MatcherMock.On("MatchSongs", []types.SongRef{first, second}, options).Return([]*types.Track{nil, nil}, nil)
+
+				var saved playlistGaps
+				host.KVStoreMock.On("Set", key, mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &saved)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(job.stats.skipped["Generated Daily Jams"]).To(Equal("no missing tracks found in the library"))
+				Expect(saved.ScannedAt).To(Equal(int64(100)))
+				Expect(saved.Tracks).To(Equal(gaps.Tracks))
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(2))
+			})
+
+			It("should forget the missing tracks of a playlist changed since it was written", func() {
+				gaps.Songs = []string{"another song"}
+				mockGaps()
+				host.KVStoreMock.On("Delete", key).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+				host.KVStoreMock.AssertCalled(GinkgoT(), "Delete", key)
+			})
+
+			It("should rewrite the comment once every missing track was found", func() {
+				comment := rematchedComment("Header\nTracks not matched A by B, E by F\nTracks excluded by rating rule: C by D", []string{"A by B", "E by F"}, []string{"E by F"}, []string{"A by B"})
+				Expect(comment).To(Equal("Header\nTracks not matched E by F\nTracks excluded by rating rule: C by D\nMatched after a library scan: A by B"))
+
+				comment = rematchedComment(comment, []string{"E by F"}, []string{}, []string{"E by F"})
+				Expect(comment).To(Equal("Header\nTracks excluded by rating rule: C by D\nMatched after a library scan: A by B, E by F"))
+			})
+		})
+
+		Describe("matchTracksUpTo", func() {
+			It("should not search for substitutes once enough tracks are matched", func() {
+				job.FallbackCount = 15
+				known := types.SongRef{Name: "world.execute(me);", MBID: "9980309d-3480-4e7e-89ce-fce971a452be"}
+				missing := types.SongRef{Name: "Rubber Human", MBID: "3f1e0c55-4f0c-4f6a-9b3b-2b8e3f0b6c11", Artists: []types.ArtistRef{{Name: "Mili", MBID: "d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"}}}
+				match := &types.Track{ID: "1234", Title: "world.execute(me);", Artist: "Mili"}
+				host.MatcherMock.On("MatchSongs", []types.SongRef{known, missing}, host.MatchOptions{Username: "username"}).Return([]*types.Track{match, nil}, nil)
+
+				matches, kinds, err := job.matchTracksUpTo([]types.SongRef{known, missing}, 1)
+				Expect(err).To(BeNil())
+				Expect(matches).To(Equal([]*types.Track{match, nil}))
+				Expect(kinds).To(BeEmpty())
+				Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+			})
+		})
+
+		Describe("dispatchImport", func() {
+			// Note, I will not be testing the "updatePlaylist" subsonic call here
+			// I am assuming it just works in general (or fails).
+			const URL = lbzEndpoint + "/playlist/" + EMPTY_UUID
+			var (
+				SINGLE_ARTIST    = types.SongRef{Name: "world.execute(me);", MBID: "9980309d-3480-4e7e-89ce-fce971a452be", Artists: []types.ArtistRef{{Name: "Mili", MBID: "d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"}}, Album: "Miracle Milk", DurationMs: 211912}
+				MULTIPLE_ARTISTS = types.SongRef{
+					Name:       "イザナ平原/夜",
+					MBID:       "7e4bb014-51d5-4943-adb1-683e066a5220",
 					Album:      "ゼノブレイド3 オリジナル・サウンドトラック",
 					DurationMs: 249946,
 					Artists: []types.ArtistRef{
@@ -515,7 +2530,7 @@ var _ = Describe("Dispatcher", func() {
 				setupResponse(request, 404, "getPlaylist.error", nil, false)
 
 				err := job.Dispatch()
-				Expect(err).To(Equal(retry.FatalError("ListenBrainz HTTP Error. Code: 400, Error: Provided playlist ID is invalid.")))
+				Expect(err).To(Equal(retry.NotFoundError(errors.New("ListenBrainz HTTP Error. Code: 400, Error: Provided playlist ID is invalid."))))
 			})
 
 			DescribeTable("should retry on recoverable error", func(recoverable error) {
@@ -523,7 +2538,7 @@ var _ = Describe("Dispatcher", func() {
 				request := testdata.MakeLbzRequest(URL, "", nil)
 				setupResponse(request, 0, "", recoverable, false)
 				err := job.Dispatch()
-				Expect(err).To(Equal(retry.TempError(recoverable)))
+				Expect(err).To(Equal(retry.TransientError(recoverable, 0)))
 			},
 				Entry("connection reset", CONNECTION_RESET),
 				Entry("timeout", CONTEXT_DEADLINE),
@@ -618,6 +2633,293 @@ var _ = Describe("Dispatcher", func() {
 				Entry("rating is not excluded, but rating map exists", []bool{true, true}, map[int32]bool{3: false, 2: false}, MATCH_SINGLE.ID, MATCH_MULTIPLE.ID),
 				Entry("all ratings are excluded, both matches", []bool{true, true}, map[int32]bool{0: false, 1: false}),
 			)
+
+			It("should not fetch, match or update a playlist that is unchanged upstream", func() {
+				job.Import = &importJob{Name: "Generated Daily Jams", LbzId: EMPTY_UUID}
+
+				request := testdata.MakeLbzRequest(URL+"?fetch_metadata=false", "", nil)
+				setupResponse(request, 200, "getPlaylist.twoTracks", nil, false)
+
+				state, marshalErr := json.Marshal(playlistState{LbzId: EMPTY_UUID})
+				Expect(marshalErr).To(BeNil())
+				host.KVStoreMock.ExpectedCalls = nil
+				host.KVStoreMock.On("Get", "playlist/username/Generated%20Daily%20Jams").Return(state, true, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.HTTPMock.Calls).To(HaveLen(1))
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(1))
+			})
+
+			It("should fetch the whole playlist once it changed upstream", func() {
+				job.Import = &importJob{Name: "Generated Daily Jams", LbzId: EMPTY_UUID}
+
+				setupResponse(testdata.MakeLbzRequest(URL+"?fetch_metadata=false", "", nil), 200, "getPlaylist.twoTracks", nil, false)
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 404, "getPlaylist.error", nil, false)
+
+				state, marshalErr := json.Marshal(playlistState{LbzId: EMPTY_UUID, Updated: time.Now()})
+				Expect(marshalErr).To(BeNil())
+				host.KVStoreMock.ExpectedCalls = nil
+				host.KVStoreMock.On("Get", "playlist/username/Generated%20Daily%20Jams").Return(state, true, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+
+				err := job.Dispatch()
+				Expect(err).To(Equal(retry.NotFoundError(errors.New("ListenBrainz HTTP Error. Code: 400, Error: Provided playlist ID is invalid."))))
+				Expect(host.HTTPMock.Calls).To(HaveLen(2))
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+			})
+
+			It("should store a diff and not touch the playlist on a dry run", func() {
+				job.Import = &importJob{Name: "Generated Daily Jams", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.DryRun = true
+
+				request := testdata.MakeLbzRequest(URL, "", nil)
+				setupResponse(request, 200, "getPlaylist.twoTracks", nil, false)
+
+				host.MatcherMock.On("MatchSongs", MULTIPLE_SONG_MATCH, host.MatchOptions{Username: "username"}).Return(MATCHES, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "existingPlaylists")
+				testdata.MockSubsonicResponse("username", "getPlaylist", &url.Values{"id": []string{"C8hOrsjiVnnHZTXqxLs57t"}}, "createPlaylist")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Generated%20Daily%20Jams", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(2))
+
+				Expect(diff.Added).To(Equal([]diffTrack{
+					{ID: MATCH_SINGLE.ID, Title: MATCH_SINGLE.Title, Artist: MATCH_SINGLE.Artist},
+					{ID: MATCH_MULTIPLE.ID, Title: MATCH_MULTIPLE.Title, Artist: MATCH_MULTIPLE.Artist},
+				}))
+				Expect(diff.Removed).To(Equal([]diffTrack{{ID: "cd020be4e71f3f9a1856ebc89741f4d9", Title: "world.execute(me);", Artist: "Mili"}}))
+				Expect(diff.Kept).To(BeEmpty())
+			})
+
+			It("should exclude recordings hated on ListenBrainz", func() {
+				job.Import = &importJob{Name: "Generated Daily Jams", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.LbzUsername = "test"
+				job.DryRun = true
+				job.ExcludeHated = true
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getPlaylist.twoTracks", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/feedback/user/test/get-feedback?count=1000&offset=0", "", nil), 200, "feedback.hated", nil, false)
+
+				host.MatcherMock.On("MatchSongs", MULTIPLE_SONG_MATCH, host.MatchOptions{Username: "username"}).Return(MATCHES, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/Generated%20Daily%20Jams", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(Equal([]diffTrack{{ID: MATCH_MULTIPLE.ID, Title: MATCH_MULTIPLE.Title, Artist: MATCH_MULTIPLE.Artist}}))
+				Expect(diff.Comment).To(ContainSubstring("Tracks excluded as hated on ListenBrainz: world.execute(me); by Mili"))
+			})
+
+			It("should substitute a track by the same artist when fallback is enabled", func() {
+				job.Import = &importJob{Name: "a playlist", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.FallbackCount = 15
+
+				request := testdata.MakeLbzRequest(URL, "", nil)
+				setupResponse(request, 200, "getPlaylist.twoTracks", nil, false)
+
+				host.MatcherMock.On("MatchSongs", MULTIPLE_SONG_MATCH, host.MatchOptions{Username: "username"}).Return([]*types.Track{nil, MATCH_MULTIPLE}, nil)
+
+				testdata.MockSubsonicResponse("username", "getArtists", nil, "getArtists")
+				testdata.MockSubsonicResponse("username", "search3", &url.Values{
+					"query":       []string{"Mili"},
+					"artistCount": []string{"0"},
+					"albumCount":  []string{"0"},
+					"songCount":   []string{"15"},
+				}, "search3")
+
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+				testdata.MockSubsonicResponse("username", "createPlaylist", &url.Values{
+					"name":   []string{"a playlist"},
+					"songId": []string{"cd020be4e71f3f9a1856ebc89741f4d9", MATCH_MULTIPLE.ID},
+				}, "createPlaylist")
+
+				comment := "Imported from playlist https://listenbrainz.org/playlist/00000000-0000-0000-0000-000000000000\nUpdated on: 0001-01-01T00:00:00Z" +
+					"\nFallback substitutions: world.execute(me); by Mili (for world.execute(me); by Mili)"
+				testdata.MockSubsonicResponse("username", "updatePlaylist", &url.Values{
+					"comment":    []string{comment},
+					"playlistId": []string{"C8hOrsjiVnnHZTXqxLs57t"},
+				}, "ping.success")
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.SubsonicAPIMock.Calls).To(HaveLen(5))
+			})
+
+			It("should exclude a fallback substitute hated on ListenBrainz", func() {
+				job.Import = &importJob{Name: "a playlist", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.LbzUsername = "test"
+				job.FallbackCount = 15
+				job.DryRun = true
+				job.ExcludeHated = true
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getPlaylist.twoTracks", nil, false)
+				setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/feedback/user/test/get-feedback?count=1000&offset=0", "", nil), 200, "feedback.hatedSubstitute", nil, false)
+
+				// The first song by Mili is already in the playlist, so Rubber Human, which is hated, substitutes world.execute(me);
+				matched := &types.Track{ID: "cd020be4e71f3f9a1856ebc89741f4d9", Title: "yzana plain / night", Artist: "ACE, TOMOri Kudo, CHiCO"}
+				host.MatcherMock.On("MatchSongs", MULTIPLE_SONG_MATCH, host.MatchOptions{Username: "username"}).Return([]*types.Track{nil, matched}, nil)
+
+				testdata.MockSubsonicResponse("username", "getArtists", nil, "getArtists")
+				testdata.MockSubsonicResponse("username", "search3", &url.Values{
+					"query":       []string{"Mili"},
+					"artistCount": []string{"0"},
+					"albumCount":  []string{"0"},
+					"songCount":   []string{"15"},
+				}, "search3")
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/a%20playlist", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(diff.Added).To(Equal([]diffTrack{{ID: matched.ID, Title: matched.Title, Artist: matched.Artist}}))
+				Expect(diff.Comment).To(ContainSubstring("Tracks excluded as hated on ListenBrainz: Rubber Human by Mili"))
+				Expect(diff.Comment).NotTo(ContainSubstring("Fallback substitutions"))
+			})
+
+			It("should prefer another copy of a recording", func() {
+				job.Import = &importJob{Name: "a playlist", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.DryRun = true
+				job.Preference = &versionPreference{Quality: true}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getPlaylist.twoTracks", nil, false)
+				host.MatcherMock.On("MatchSongs", MULTIPLE_SONG_MATCH, host.MatchOptions{Username: "username"}).Return(MATCHES, nil)
+
+				search := func(title string) *url.Values {
+					return &url.Values{"query": []string{title}, "artistCount": []string{"0"}, "albumCount": []string{"0"}, "songCount": []string{"20"}}
+				}
+				testdata.MockSubsonicResponse("username", "search3", search(MATCH_SINGLE.Title), "search3.duplicates")
+				testdata.MockSubsonicResponse("username", "search3", search(MATCH_MULTIPLE.Title), "search3.empty")
+
+				lossless := &types.Track{ID: "a7c3e5d1b9f24e6c8d0a2b4f6e8c1d3a", Title: "world.execute(me);", Artist: "Mili", Album: "Mili Best", Suffix: "flac", BitRate: 1024}
+				host.MatcherMock.On("MatchSongs", []types.SongRef{
+					{ID: "cd020be4e71f3f9a1856ebc89741f4d9", Name: "world.execute(me);", MBID: SINGLE_ARTIST.MBID},
+					{ID: "a7c3e5d1b9f24e6c8d0a2b4f6e8c1d3a", Name: "world.execute(me);", MBID: SINGLE_ARTIST.MBID},
+				}, host.MatchOptions{Username: "username"}).Return([]*types.Track{
+					{ID: "cd020be4e71f3f9a1856ebc89741f4d9", Title: "world.execute(me);", Artist: "Mili", Suffix: "mp3", BitRate: 287},
+					lossless,
+				}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/a%20playlist", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.MatcherMock.Calls).To(HaveLen(2))
+				Expect(diff.Added).To(Equal([]diffTrack{
+					{ID: lossless.ID, Title: lossless.Title, Artist: lossless.Artist},
+					{ID: MATCH_MULTIPLE.ID, Title: MATCH_MULTIPLE.Title, Artist: MATCH_MULTIPLE.Artist},
+				}))
+			})
+
+			It("should store a match report, with the missing tracks as JSPF", func() {
+				job.Import = &importJob{Name: "a playlist", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 2: true, 3: true, 4: true, 5: true}
+				job.DryRun = true
+				job.MatchReports = true
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getPlaylist.twoTracks", nil, false)
+				host.MatcherMock.On("MatchSongs", MULTIPLE_SONG_MATCH, host.MatchOptions{Username: "username"}).Return([]*types.Track{MATCH_SINGLE, nil}, nil)
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var report matchReport
+				host.KVStoreMock.On("SetWithTTL", "report/username/a%20playlist", mock.Anything, int64(30*24*60*60)).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &report)).To(Succeed())
+				}).Return(nil)
+
+				var missing jspfDocument
+				host.KVStoreMock.On("SetWithTTL", "missing/username/a%20playlist", mock.Anything, int64(30*24*60*60)).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &missing)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(report.Playlist).To(Equal("a playlist"))
+				Expect(report.Tracks).To(Equal([]reportTrack{
+					{
+						Title:         SINGLE_ARTIST.Name,
+						Creator:       "Mili",
+						Album:         SINGLE_ARTIST.Album,
+						DurationMs:    SINGLE_ARTIST.DurationMs,
+						RecordingMBID: SINGLE_ARTIST.MBID,
+						ArtistMBIDs:   []string{"d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"},
+						Status:        trackExcluded,
+						SongID:        MATCH_SINGLE.ID,
+					},
+					{
+						Position:      1,
+						Title:         MULTIPLE_ARTISTS.Name,
+						Creator:       "ACE(工藤ともり、CHiCO)",
+						Album:         MULTIPLE_ARTISTS.Album,
+						DurationMs:    MULTIPLE_ARTISTS.DurationMs,
+						RecordingMBID: MULTIPLE_ARTISTS.MBID,
+						ArtistMBIDs:   []string{"16563fb9-c2b5-4ab7-b5b1-7b6592f862a1", "59e83bb6-e8b7-44e0-bea2-2275400850e5", "a2b0affd-963f-46a3-9a8d-5b9d1332ccb3"},
+						Status:        trackMissing,
+					},
+				}))
+				Expect(missing.Playlist.Title).To(Equal("a playlist: missing tracks"))
+				Expect(missing.Playlist.Track).To(Equal([]jspfTrack{{
+					Identifier: []string{"https://musicbrainz.org/recording/" + MULTIPLE_ARTISTS.MBID},
+					Title:      MULTIPLE_ARTISTS.Name,
+					Creator:    "ACE(工藤ともり、CHiCO)",
+					Album:      MULTIPLE_ARTISTS.Album,
+					Duration:   MULTIPLE_ARTISTS.DurationMs,
+				}}))
+			})
+
+			It("should apply match overrides without calling the matcher", func() {
+				job.Import = &importJob{Name: "a playlist", LbzId: EMPTY_UUID}
+				job.Ratings = map[int32]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true}
+				job.FallbackCount = 15
+				job.DryRun = true
+				job.Overrides = map[string]string{
+					SINGLE_ARTIST.MBID:    "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98",
+					MULTIPLE_ARTISTS.MBID: "",
+				}
+
+				setupResponse(testdata.MakeLbzRequest(URL, "", nil), 200, "getPlaylist.twoTracks", nil, false)
+				testdata.MockSubsonicResponse("username", "getSong", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}}, "getSong")
+				testdata.MockSubsonicResponse("username", "getPlaylists", &url.Values{"username": []string{"username"}}, "noPlaylists")
+
+				var diff playlistDiff
+				host.KVStoreMock.On("Set", "dryrun/username/a%20playlist", mock.Anything).Run(func(args mock.Arguments) {
+					Expect(json.Unmarshal(args.Get(1).([]byte), &diff)).To(Succeed())
+				}).Return(nil)
+
+				err := job.Dispatch()
+				Expect(err).To(BeNil())
+				Expect(host.MatcherMock.Calls).To(BeEmpty())
+				Expect(diff.Added).To(Equal([]diffTrack{{ID: "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98", Title: "Rubber Human", Artist: "Mili"}}))
+				Expect(diff.Comment).To(HaveSuffix(
+					"\nMatched by override: Rubber Human by Mili (for world.execute(me); by Mili)" +
+						"\nNever matched by override: イザナ平原/夜 by ACE(工藤ともり、CHiCO)",
+				))
+				Expect(job.stats.playlist("a playlist").missing).To(Equal(0))
+				Expect(job.stats.playlist("a playlist").excluded).To(Equal(1))
+			})
 		})
 	})
 })
@@ -0,0 +1,104 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"net/url"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const dryRunPrefix = "dryrun/"
+
+type diffTrack struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+}
+
+type playlistDiff struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Username  string      `json:"username"`
+	Playlist  string      `json:"playlist"`
+	Comment   string      `json:"comment"`
+	Added     []diffTrack `json:"added"`
+	Removed   []diffTrack `json:"removed"`
+	Kept      []diffTrack `json:"kept"`
+}
+
+// Computes the difference between the current playlist and the songs that would replace it.
+// Added and kept tracks are in the new playlist order, removed tracks in the existing order
+func diffPlaylist(current []subsonic.Child, songs []*types.Track) (added, removed, kept []diffTrack) {
+	added, removed, kept = []diffTrack{}, []diffTrack{}, []diffTrack{}
+
+	existing := map[string]bool{}
+	for _, song := range current {
+		existing[song.Id] = true
+	}
+
+	updated := map[string]bool{}
+	for _, song := range songs {
+		updated[song.ID] = true
+
+		track := diffTrack{ID: song.ID, Title: song.Title, Artist: song.Artist}
+		if existing[song.ID] {
+			kept = append(kept, track)
+		} else {
+			added = append(added, track)
+		}
+	}
+
+	for _, song := range current {
+		if !updated[song.Id] {
+			removed = append(removed, diffTrack{ID: song.Id, Title: song.Title, Artist: song.Artist})
+		}
+	}
+
+	return added, removed, kept
+}
+
+// Creates or replaces the playlist with the given songs.
+// For a dry run, the playlist is left untouched, and the difference is logged and stored instead
+func (j *Job) writePlaylist(name, comment string, songs []*types.Track) *retry.Error {
+	if !j.DryRun {
+		songIds := make([]string, len(songs))
+		for idx, song := range songs {
+			songIds[idx] = song.ID
+		}
+
+		return subsonic.UpdatePlaylist(j.Username, name, comment, songIds)
+	}
+
+	current, err := subsonic.GetPlaylistEntries(j.Username, name)
+	if err != nil {
+		return err
+	}
+
+	diff := playlistDiff{
+		Timestamp: time.Now(),
+		Username:  j.Username,
+		Playlist:  name,
+		Comment:   comment,
+	}
+	diff.Added, diff.Removed, diff.Kept = diffPlaylist(current, songs)
+
+	j.stats.playlist(name).message = fmt.Sprintf("dry run: %d added, %d removed, %d kept", len(diff.Added), len(diff.Removed), len(diff.Kept))
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Dry run for playlist `%s` for user %s: %d added, %d removed, %d kept", name, j.Username, len(diff.Added), len(diff.Removed), len(diff.Kept)))
+
+	payload, jsonErr := json.Marshal(diff)
+	if jsonErr == nil {
+		pdk.Log(pdk.LogDebug, "Dry run diff: "+string(payload))
+	}
+
+	key := fmt.Sprintf("%s%s/%s", dryRunPrefix, url.PathEscape(j.Username), url.PathEscape(name))
+	if storeErr := store.Set(key, diff); storeErr != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to store dry run diff %s: %v", key, storeErr))
+	}
+
+	return nil
+}
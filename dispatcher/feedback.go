@@ -0,0 +1,275 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"net/url"
+	"strconv"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const (
+	// How much the score of a loved recording is multiplied by when sampling a generated playlist
+	lovedBoost = 3.0
+	// The rating given in Navidrome to an unrated song hated on ListenBrainz
+	pulledHateRating = 1
+
+	// The feedback of every recording as of the last sync, per user
+	feedbackStatePrefix = "feedback/"
+)
+
+type feedbackChange struct {
+	mbid  string
+	score int
+}
+
+// The changes needed to bring Navidrome and ListenBrainz feedback in line
+type feedbackPlan struct {
+	// Feedback to submit to ListenBrainz. A score of 0 removes the feedback
+	push []feedbackChange
+	// Songs to star in Navidrome
+	star []string
+	// Songs to rate as hated in Navidrome
+	rate []string
+	// Songs to unstar in Navidrome
+	unstar []string
+	// Songs whose hated rating is removed in Navidrome
+	unrate []string
+	// The feedback of every recording once the plan is applied, to compare the next sync against
+	synced map[string]int
+}
+
+func feedbackStateKey(username string) string {
+	return feedbackStatePrefix + url.PathEscape(username)
+}
+
+// Fetches the ListenBrainz feedback of this user, if any feedback option is enabled.
+// Feedback only refines a playlist, so a non-retryable error is logged and the playlist is built without it
+func (j *Job) loadFeedback() (map[string]int, *retry.Error) {
+	if !j.ExcludeHated && !j.BoostLoved {
+		return nil, nil
+	}
+
+	feedback, err := listenbrainz.GetFeedback(j.LbzUsername, j.LbzToken)
+	if err != nil {
+		if err.Retryable {
+			pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch feedback for user %s: %v", j.Username, err.Error))
+			return nil, err
+		}
+
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to fetch feedback for user %s, ignoring it: %v", j.Username, err.Error))
+		return nil, nil
+	}
+
+	return feedback, nil
+}
+
+// The recording whose feedback applies to a matched song. A fallback substitute is a different recording than the one requested
+func feedbackMBID(ref types.SongRef, song *types.Track, kind matchKind) string {
+	if kind == matchFallback {
+		return song.MbzRecordingID
+	}
+
+	return ref.MBID
+}
+
+func (j *Job) isHated(feedback map[string]int, mbid string) bool {
+	return j.ExcludeHated && mbid != "" && feedback[mbid] == listenbrainz.FeedbackHated
+}
+
+func (j *Job) isLoved(feedback map[string]int, mbid string) bool {
+	return j.BoostLoved && mbid != "" && feedback[mbid] == listenbrainz.FeedbackLoved
+}
+
+// The feedback a song has in Navidrome: loved if starred, hated if rated at or below hateRating, otherwise none
+func localFeedback(song *subsonic.Child, hateRating int) int {
+	if song.Starred != nil {
+		return listenbrainz.FeedbackLoved
+	}
+
+	if song.UserRating > 0 && int(song.UserRating) <= hateRating {
+		return listenbrainz.FeedbackHated
+	}
+
+	return 0
+}
+
+// Compares the feedback of every recording in the library on both sides with the feedback as of the last sync.
+// A side that changed since then, including by removing its feedback, is synced to the other side.
+// If both changed, feedback set in Navidrome takes precedence. Without a hated rating, hated feedback is not synced
+func planFeedbackSync(songs []subsonic.Child, remote, synced map[string]int, hateRating int) feedbackPlan {
+	plan := feedbackPlan{push: []feedbackChange{}, star: []string{}, rate: []string{}, unstar: []string{}, unrate: []string{}, synced: map[string]int{}}
+
+	// Several songs can share a recording
+	mbids := []string{}
+	recordings := map[string][]*subsonic.Child{}
+
+	for idx := range songs {
+		song := &songs[idx]
+		mbid := song.MusicBrainzId
+		if mbid == "" {
+			continue
+		}
+
+		if _, ok := recordings[mbid]; !ok {
+			mbids = append(mbids, mbid)
+		}
+		recordings[mbid] = append(recordings[mbid], song)
+	}
+
+	for _, mbid := range mbids {
+		// A starred song takes precedence over a hated one of the same recording
+		local := 0
+		for _, song := range recordings[mbid] {
+			if feedback := localFeedback(song, hateRating); feedback == listenbrainz.FeedbackLoved || local == 0 {
+				local = feedback
+			}
+		}
+
+		theirs := remote[mbid]
+		if theirs == listenbrainz.FeedbackHated && hateRating <= 0 {
+			theirs = 0
+		}
+
+		base := synced[mbid]
+		result := local
+
+		switch {
+		case local == theirs:
+		case local != base && (theirs == base || local != 0):
+			plan.push = append(plan.push, feedbackChange{mbid: mbid, score: local})
+		default:
+			result = plan.pull(recordings[mbid], local, theirs, hateRating)
+		}
+
+		if result != 0 {
+			plan.synced[mbid] = result
+		}
+	}
+
+	return plan
+}
+
+// Brings the songs of a recording from the local feedback to the remote one, returning the local feedback once applied.
+// Ratings set in Navidrome other than hated ones are kept
+func (p *feedbackPlan) pull(songs []*subsonic.Child, local, remote, hateRating int) int {
+	if local == listenbrainz.FeedbackLoved {
+		for _, song := range songs {
+			if song.Starred != nil {
+				p.unstar = append(p.unstar, song.Id)
+			}
+		}
+		local = 0
+	}
+
+	if local == listenbrainz.FeedbackHated {
+		for _, song := range songs {
+			if song.UserRating > 0 && int(song.UserRating) <= hateRating {
+				p.unrate = append(p.unrate, song.Id)
+			}
+		}
+		local = 0
+	}
+
+	switch remote {
+	case listenbrainz.FeedbackLoved:
+		for _, song := range songs {
+			p.star = append(p.star, song.Id)
+		}
+		return remote
+	case listenbrainz.FeedbackHated:
+		for _, song := range songs {
+			if song.UserRating == 0 {
+				p.rate = append(p.rate, song.Id)
+				local = remote
+			}
+		}
+	}
+
+	return local
+}
+
+func (j *Job) dispatchFeedbackSync() *retry.Error {
+	if j.Feedback == nil {
+		return retry.FatalError("attempting to sync feedback without feedback payload")
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Syncing feedback between Navidrome and ListenBrainz for user %s", j.Username))
+
+	songs, err := subsonic.LibrarySongs(j.Username)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch library songs for user %s: %v", j.Username, err.Error))
+		return err
+	}
+
+	remote, err := listenbrainz.GetFeedback(j.LbzUsername, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch feedback for user %s: %v", j.Username, err.Error))
+		return err
+	}
+
+	key := feedbackStateKey(j.Username)
+	synced := map[string]int{}
+	if _, storeErr := store.Get(key, &synced); storeErr != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read the last synced feedback of user %s, syncing as if it never was: %v", j.Username, storeErr))
+	}
+
+	plan := planFeedbackSync(songs, remote, synced, j.Feedback.HateRating)
+	summary := fmt.Sprintf("%d feedback(s) to ListenBrainz, %d star(s), %d unstar(s) and %d rating(s) to Navidrome",
+		len(plan.push), len(plan.star), len(plan.unstar), len(plan.rate)+len(plan.unrate))
+
+	if j.DryRun {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Dry run for feedback of user %s: would sync %s", j.Username, summary))
+		return nil
+	}
+
+	// Every step is idempotent, so a retried job picks up where this one failed
+	for _, change := range plan.push {
+		err = listenbrainz.SubmitFeedback(change.mbid, change.score, j.LbzToken)
+		if err != nil {
+			pdk.Log(pdk.LogError, fmt.Sprintf("Unable to submit feedback for recording %s for user %s: %v", change.mbid, j.Username, err.Error))
+			return err
+		}
+	}
+
+	if len(plan.star) > 0 {
+		_, err = subsonic.Call("star", j.Username, &url.Values{"id": plan.star})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plan.unstar) > 0 {
+		_, err = subsonic.Call("unstar", j.Username, &url.Values{"id": plan.unstar})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range plan.rate {
+		_, err = subsonic.Call("setRating", j.Username, &url.Values{"id": []string{id}, "rating": []string{strconv.Itoa(pulledHateRating)}})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range plan.unrate {
+		_, err = subsonic.Call("setRating", j.Username, &url.Values{"id": []string{id}, "rating": []string{"0"}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only saved once everything is applied, so a failed sync is planned again from the same state
+	if storeErr := store.Set(key, plan.synced); storeErr != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save the synced feedback of user %s: %v", j.Username, storeErr))
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Synced %s for user %s", summary, j.Username))
+	return nil
+}
@@ -0,0 +1,61 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+// The number of all-time top recordings a forgotten favorites playlist picks from
+const forgottenCandidates = 1000
+
+// Fetches the all-time top recordings of the user, and matches them against the library.
+// The track age of the playlist then keeps only those not played recently, so that favorites can be rediscovered.
+// Recordings are weighted by listen count. Fallback substitutes are only searched for until needed tracks are matched
+func (j *Job) forgottenPool(now time.Time, needed int) (*recommendationPool, *retry.Error) {
+	top, err := listenbrainz.GetTopRecordings(j.LbzUsername, j.LbzToken, "all_time", forgottenCandidates)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch top recordings for user %s: %v", j.Username, err.Error))
+		return nil, err
+	}
+
+	recordings := top.Payload.Recordings
+	tracks := make([]types.SongRef, len(recordings))
+	scores := make([]float64, len(recordings))
+
+	for idx, recording := range recordings {
+		tracks[idx].Name = recording.TrackName
+		tracks[idx].MBID = recording.RecordingMBID
+		tracks[idx].Album = recording.ReleaseName
+		tracks[idx].AlbumMBID = recording.ReleaseMBID
+
+		tracks[idx].Artists = make([]types.ArtistRef, len(recording.Artists))
+
+		for artistIdx, artist := range recording.Artists {
+			tracks[idx].Artists[artistIdx].Name = artist.ArtistCreditName
+			tracks[idx].Artists[artistIdx].MBID = artist.MBID
+		}
+
+		scores[idx] = float64(recording.ListenCount)
+	}
+
+	matches, kinds, err := j.matchTracksUpTo(tracks, needed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recommendationPool{
+		label:     "all-time top recordings",
+		generated: now,
+		count:     len(recordings),
+		updated:   time.Unix(top.Payload.LastUpdated, 0),
+		tracks:    tracks,
+		scores:    scores,
+		matches:   matches,
+		kinds:     kinds,
+	}, nil
+}
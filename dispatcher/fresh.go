@@ -0,0 +1,119 @@
+package dispatcher
+
+import (
+	"cmp"
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/subsonic"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+// Keeps the releases from past days ago to future days ahead, newest first. Duplicate releases are dropped
+func freshInWindow(releases []listenbrainz.FreshRelease, now time.Time, past, future int) []listenbrainz.FreshRelease {
+	oldest := now.AddDate(0, 0, -past).Format(time.DateOnly)
+	newest := now.AddDate(0, 0, future).Format(time.DateOnly)
+
+	kept := []listenbrainz.FreshRelease{}
+	seen := map[string]bool{}
+
+	for _, release := range releases {
+		if release.ReleaseDate < oldest || release.ReleaseDate > newest || seen[release.ReleaseMBID] {
+			continue
+		}
+
+		seen[release.ReleaseMBID] = true
+		kept = append(kept, release)
+	}
+
+	slices.SortStableFunc(kept, func(a, b listenbrainz.FreshRelease) int {
+		return cmp.Compare(b.ReleaseDate, a.ReleaseDate)
+	})
+
+	return kept
+}
+
+// Builds a playlist of the fresh releases of artists the user listens to which are in the library, newest release first
+func (j *Job) dispatchFreshReleases() *retry.Error {
+	if j.Fresh == nil {
+		return retry.FatalError("attempting to call fresh releases job without fresh payload")
+	}
+
+	name := j.Fresh.Name
+	now := time.Now()
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Building fresh releases playlist `%s` for user %s", name, j.Username))
+
+	releases, err := listenbrainz.GetFreshReleases(j.LbzUsername, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch fresh releases for user %s: %v", j.Username, err.Error))
+		return err
+	}
+
+	releases = freshInWindow(releases, now, j.Fresh.Past, j.Fresh.Future)
+
+	songs := []*types.Track{}
+	found := []string{}
+	missing := 0
+	excluded := 0
+
+	for _, release := range releases {
+		album, err := subsonic.FindAlbum(j.Username, release.ReleaseName, release.ReleaseMBID)
+		if err != nil {
+			pdk.Log(pdk.LogError, fmt.Sprintf("Unable to search for release `%s` for user %s: %v", release.ReleaseName, j.Username, err.Error))
+			return err
+		}
+
+		if album == nil {
+			missing += 1
+			continue
+		}
+
+		found = append(found, fmt.Sprintf("%s by %s (%s)", release.ReleaseName, release.ArtistCreditName, release.ReleaseDate))
+
+		for _, song := range album.Song {
+			if j.Ratings[song.UserRating] {
+				songs = append(songs, song.ToTrack())
+			} else {
+				excluded += 1
+			}
+		}
+	}
+
+	stats := j.stats.playlist(name)
+	stats.matched = len(songs)
+	stats.missing = missing
+	stats.excluded = excluded
+
+	if len(songs) == 0 {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("No fresh releases found in the library for playlist %s. Refusing to create/update", name))
+		stats.message = "no fresh releases found in library, playlist not updated"
+		return nil
+	}
+
+	comment := fmt.Sprintf("Fresh releases from %s to %s, updated on %s\nReleases: %s\nReleases not in library: %d",
+		now.AddDate(0, 0, -j.Fresh.Past).Format(time.DateOnly),
+		now.AddDate(0, 0, j.Fresh.Future).Format(time.DateOnly),
+		now.Format(time.RFC1123),
+		strings.Join(found, ", "),
+		missing,
+	)
+
+	if excluded > 0 {
+		comment += fmt.Sprintf("\nTracks excluded by rating rule: %d", excluded)
+	}
+
+	err = j.writePlaylist(name, comment, songs)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to update fresh releases playlist `%s` for user %s: %v", name, j.Username, err.Error))
+		return err
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Successfully updated fresh releases playlist `%s` for user %s", name, j.Username))
+	return nil
+}
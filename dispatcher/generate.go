@@ -0,0 +1,457 @@
+package dispatcher
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const (
+	defaultGeneratedSize = 50
+	// The typical length of a song, to estimate how many tracks fill a playlist with a duration target
+	typicalTrackSeconds = 180
+
+	// Generated playlists pick from the recommendations of the user by default
+	sourceRecommendations = "recommendations"
+	sourceForgotten       = "forgotten"
+)
+
+// The recommendations fetched and matched in a single run, shared by every generated playlist of the job with the same source
+type recommendationPool struct {
+	// What the tracks are, for the playlist comment
+	label     string
+	generated time.Time
+	count     int
+	updated   time.Time
+	tracks    []types.SongRef
+	scores    []float64
+	matches   []*types.Track
+	kinds     map[int]matchKind
+	// ListenBrainz feedback of the user, by recording MBID. Nil if no feedback option is enabled
+	feedback map[string]int
+	// When each recording was last listened to according to ListenBrainz. Nil if disabled
+	listened map[string]time.Time
+}
+
+// Whether any of the recordings was listened to on ListenBrainz within the last trackAge days
+func (p *recommendationPool) listenedRecently(trackAge int, mbids ...string) bool {
+	for _, mbid := range mbids {
+		listenedAt, ok := p.listened[mbid]
+		if ok && mbid != "" && p.generated.Sub(listenedAt).Hours() < float64(trackAge*24) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fetches the recent ListenBrainz listens of this user, covering the longest track age of the job.
+// Like feedback, a non-retryable error is logged, and the playlists are generated using Navidrome plays only
+func (j *Job) loadRecentListens(now time.Time) (map[string]time.Time, *retry.Error) {
+	trackAge := 0
+	for _, generate := range j.Generated {
+		trackAge = max(trackAge, generate.TrackAge)
+	}
+
+	if j.RecentListenLimit <= 0 || trackAge <= 0 {
+		return nil, nil
+	}
+
+	listened, err := listenbrainz.GetRecentListens(j.LbzUsername, j.LbzToken, now.AddDate(0, 0, -trackAge), j.RecentListenLimit)
+	if err != nil {
+		if err.Retryable {
+			pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch recent listens for user %s: %v", j.Username, err.Error))
+			return nil, err
+		}
+
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to fetch recent listens for user %s, ignoring them: %v", j.Username, err.Error))
+		return nil, nil
+	}
+
+	return listened, nil
+}
+
+// Fetches the recommendations of the user, with their metadata, and matches them against the library.
+// Fallback substitutes are only searched for until needed tracks are matched
+func (j *Job) recommendedPool(now time.Time, needed int) (*recommendationPool, *retry.Error) {
+	recommendations, err := listenbrainz.GetRecommendations(j.LbzUsername, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch recommendations for user %s: %v", j.Username, err.Error))
+		return nil, err
+	}
+
+	mbids := make([]string, len(recommendations.Payload.MBIDs))
+	scores := make([]float64, len(recommendations.Payload.MBIDs))
+	for idx, recording := range recommendations.Payload.MBIDs {
+		mbids[idx] = recording.RecordingMBID
+		scores[idx] = recording.Score
+	}
+
+	metadata, err := listenbrainz.LookupRecordings(mbids, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to lookup %d recordings for user %s: %v", len(mbids), j.Username, err.Error))
+		return nil, err
+	}
+
+	tracks := make([]types.SongRef, len(mbids))
+
+	for idx, mbid := range mbids {
+		recordingMetadata, ok := metadata[mbid]
+		if !ok {
+			pdk.Log(pdk.LogWarn, fmt.Sprintf("Warning: track with mbid %s not found in metadata lookup. Skipping", mbid))
+			continue
+		}
+
+		tracks[idx].Album = recordingMetadata.Release.Name
+		tracks[idx].AlbumMBID = recordingMetadata.Release.MBID
+		tracks[idx].DurationMs = recordingMetadata.Recording.Length
+		tracks[idx].Name = recordingMetadata.Recording.Name
+		tracks[idx].MBID = mbid
+
+		if len(recordingMetadata.Recording.ISRCs) > 0 {
+			tracks[idx].ISRC = recordingMetadata.Recording.ISRCs[0]
+		}
+
+		tracks[idx].Artists = make([]types.ArtistRef, len(recordingMetadata.Artist.Artists))
+
+		for artistIdx, artist := range recordingMetadata.Artist.Artists {
+			tracks[idx].Artists[artistIdx].Name = artist.Name
+			tracks[idx].Artists[artistIdx].MBID = artist.ArtistMbid
+		}
+	}
+
+	matches, kinds, err := j.matchTracksUpTo(tracks, needed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recommendationPool{
+		label:     "recommendations",
+		generated: now,
+		count:     len(mbids),
+		updated:   time.Unix(recommendations.Payload.LastUpdated, recommendations.Payload.LastUpdated),
+		tracks:    tracks,
+		scores:    scores,
+		matches:   matches,
+		kinds:     kinds,
+	}, nil
+}
+
+// Fetches and matches the tracks a generated playlist picks from
+func (j *Job) loadPool(source string, now time.Time) (*recommendationPool, *retry.Error) {
+	needed := j.poolNeeds(source)
+
+	if source == sourceForgotten {
+		return j.forgottenPool(now, needed)
+	}
+
+	return j.recommendedPool(now, needed)
+}
+
+// The most tracks any generated playlist of the job picks from the source, estimating the tracks of duration targets
+func (j *Job) poolNeeds(source string) int {
+	needed := 0
+
+	for _, generate := range j.Generated {
+		if generate.Source != source && (generate.Source != "" || source != sourceRecommendations) {
+			continue
+		}
+
+		target := generate.target()
+		if target.seconds > 0 {
+			needed = max(needed, int(math.Ceil(target.seconds/typicalTrackSeconds)))
+		} else {
+			needed = max(needed, target.count)
+		}
+	}
+
+	return needed
+}
+
+// How many tracks (or, if seconds is nonzero, how long) a generated playlist should be
+type playlistTarget struct {
+	count   int
+	seconds float64
+}
+
+func (t playlistTarget) isFull(count int, seconds float64) bool {
+	if t.seconds > 0 {
+		return seconds >= t.seconds
+	}
+
+	return count >= t.count
+}
+
+// A share (in percent) of this target. The count is rounded to the nearest track
+func (t playlistTarget) share(percent int) playlistTarget {
+	if percent <= 0 {
+		return playlistTarget{}
+	}
+
+	return playlistTarget{
+		count:   int(math.Round(float64(t.count*percent) / 100)),
+		seconds: t.seconds * float64(percent) / 100,
+	}
+}
+
+// A duration target (in minutes) takes precedence over the track count
+func (g *generationJob) target() playlistTarget {
+	if g.Duration > 0 {
+		return playlistTarget{seconds: float64(g.Duration * 60)}
+	}
+
+	size := g.Size
+	if size <= 0 {
+		size = defaultGeneratedSize
+	}
+
+	return playlistTarget{count: size}
+}
+
+// Returns the leading tracks up to the target
+func takeTracks(tracks []*types.Track, target playlistTarget) []*types.Track {
+	seconds := 0.0
+
+	for idx, song := range tracks {
+		if target.isFull(idx, seconds) {
+			return tracks[:idx]
+		}
+
+		seconds += song.Duration
+	}
+
+	return tracks
+}
+
+func totalDuration(tracks []*types.Track) float64 {
+	seconds := 0.0
+	for _, song := range tracks {
+		seconds += song.Duration
+	}
+	return seconds
+}
+
+// Picks the tracks of a generated playlist, in order. By default, tracks which were not played recently are preferred,
+// and tracks which were never played are only used to fill up the playlist.
+// With an unplayed ratio, that share of the playlist is reserved for tracks that were never played.
+// The artist limit applies to both
+func (g *generationJob) selectTracks(allowed, notPlayed []*types.Track) []*types.Track {
+	target := g.target()
+	candidates := []*types.Track{}
+
+	if g.UnplayedRatio <= 0 {
+		candidates = append(candidates, allowed...)
+	} else {
+		played := takeTracks(allowed, target.share(100-g.UnplayedRatio))
+		unplayed := takeTracks(notPlayed, target.share(g.UnplayedRatio))
+
+		candidates = append(candidates, played...)
+		candidates = append(candidates, unplayed...)
+
+		// If there are not enough unplayed tracks, fill the rest with played tracks
+		allowed = allowed[len(played):]
+		notPlayed = notPlayed[len(unplayed):]
+		candidates = append(candidates, allowed...)
+	}
+
+	seconds := totalDuration(candidates)
+
+	for _, song := range notPlayed {
+		if target.isFull(len(candidates), seconds) {
+			break
+		}
+
+		candidates = append(candidates, song)
+		seconds += song.Duration
+	}
+
+	songs := []*types.Track{}
+	artistCredits := map[string]int{}
+	seconds = 0
+
+outer:
+	for _, song := range candidates {
+		if target.isFull(len(songs), seconds) {
+			break
+		}
+
+		if g.ArtistLimit > 0 {
+			for _, artist := range song.Participants {
+				if artist.Role == "artist" && artistCredits[artist.ID] >= g.ArtistLimit {
+					continue outer
+				}
+			}
+
+			for _, artist := range song.Participants {
+				if artist.Role == "artist" {
+					artistCredits[artist.ID] += 1
+				}
+			}
+		}
+
+		songs = append(songs, song)
+		seconds += song.Duration
+	}
+
+	return songs
+}
+
+// A seed which is the same for a playlist of a user for the whole (local) day
+func daySeed(username, playlist string, day time.Time) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(fmt.Sprintf("%s/%s/%s", username, playlist, day.Format(time.DateOnly))))
+	return int64(hash.Sum64())
+}
+
+// Orders tracks by weighted random sampling without replacement (Efraimidis-Spirakis),
+// so that tracks with a higher recommendation score are more likely to come first.
+// Tracks without a positive score are sampled with a tiny weight, and end up last
+func weightedShuffle(tracks []*types.Track, weights map[*types.Track]float64, seed int64) []*types.Track {
+	random := rand.New(rand.NewSource(seed))
+	keys := make(map[*types.Track]float64, len(tracks))
+
+	for _, song := range tracks {
+		weight := max(weights[song], 1e-9)
+		keys[song] = math.Pow(random.Float64(), 1/weight)
+	}
+
+	shuffled := slices.Clone(tracks)
+	slices.SortStableFunc(shuffled, func(a, b *types.Track) int {
+		return cmp.Compare(keys[b], keys[a])
+	})
+
+	return shuffled
+}
+
+// Filters the shared recommendations by the rules of this playlist, and creates/updates it
+func (j *Job) generatePlaylist(g *generationJob, pool *recommendationPool) *retry.Error {
+	ratings := g.Ratings
+	if len(ratings) == 0 {
+		ratings = j.Ratings
+	}
+
+	allowedSongs := []*types.Track{}
+	notPlayed := []*types.Track{}
+	recentCount := 0
+	weights := map[*types.Track]float64{}
+
+	// Whether a song passing the filters is actually picked is only known once the playlist is selected
+	matched := j.filterMatches(pool.tracks, nil, pool.matches, pool.kinds, pool.feedback, ratings)
+	report := matched.report
+
+	for _, song := range matched.songs {
+		idx := matched.origins[song]
+		weights[song] = pool.scores[idx]
+
+		if j.isLoved(pool.feedback, feedbackMBID(pool.tracks[idx], song, pool.kinds[idx])) {
+			weights[song] *= lovedBoost
+		}
+
+		// Catches plays from other devices and scrobblers, which Navidrome does not know about
+		if pool.listenedRecently(g.TrackAge, pool.tracks[idx].MBID, song.MbzRecordingID) {
+			recentCount += 1
+			pdk.Log(pdk.LogTrace, fmt.Sprintf("Excluding track `%s` for being listened to recently on ListenBrainz", song.Title))
+			report[idx].set(trackPlayedRecently, song)
+			continue
+		}
+
+		// Forgotten favorites are favorites played long ago, which a song never played in Navidrome is not
+		if song.PlayDate == nil && g.Source == sourceForgotten {
+			pdk.Log(pdk.LogTrace, fmt.Sprintf("Excluding track `%s` for never being played", song.Title))
+			report[idx].set(trackNeverPlayed, song)
+			continue
+		}
+
+		if song.PlayDate == nil {
+			notPlayed = append(notPlayed, song)
+			continue
+		}
+
+		playTime := time.Unix(*song.PlayDate, 0)
+
+		if pool.generated.Sub(playTime).Hours() < float64(g.TrackAge*24) {
+			recentCount += 1
+			pdk.Log(pdk.LogTrace, fmt.Sprintf("Excluding track `%s` for being played recently", song.Title))
+			report[idx].set(trackPlayedRecently, song)
+			continue
+		}
+
+		allowedSongs = append(allowedSongs, song)
+	}
+
+	repeatCount := 0
+	if g.RepeatWindow > 0 {
+		recent := recentlyGenerated(j.Username, g.Name, pool.generated, g.RepeatWindow)
+
+		var playedRepeats, notPlayedRepeats int
+		allowedSongs, playedRepeats = g.avoidRepeats(allowedSongs, weights, recent)
+		notPlayed, notPlayedRepeats = g.avoidRepeats(notPlayed, weights, recent)
+		repeatCount = playedRepeats + notPlayedRepeats
+	}
+
+	stats := j.stats.playlist(g.Name)
+	stats.missing = len(matched.missing)
+	stats.excluded = len(matched.excluded) + len(matched.hated) + len(matched.never) + recentCount + repeatCount
+
+	// Sample by score, rather than taking the same top recommendations every day
+	seed := daySeed(j.Username, g.Name, pool.generated)
+	allowedSongs = weightedShuffle(allowedSongs, weights, seed)
+	notPlayed = weightedShuffle(notPlayed, weights, seed+1)
+
+	songs := g.selectTracks(allowedSongs, notPlayed)
+	stats.matched = len(songs)
+
+	selected := map[*types.Track]bool{}
+	for _, song := range songs {
+		selected[song] = true
+	}
+
+	for _, song := range matched.songs {
+		idx := matched.origins[song]
+		if report[idx].Status == addedStatus(pool.kinds[idx]) && !selected[song] {
+			report[idx].Status = trackNotSelected
+		}
+	}
+
+	j.saveReport(g.Name, report)
+
+	comment := fmt.Sprintf(
+		"Jams generated on %s with %d %s generated on %s."+
+			"\nExcluded by rating rules: %s\nTracks not found in library: %s\nExcluded for being recent: %d",
+		pool.generated.Format(time.RFC1123), pool.count, pool.label, pool.updated.Format(time.RFC1123),
+		strings.Join(matched.excluded, ", "),
+		strings.Join(matched.missing, ", "),
+		recentCount,
+	)
+
+	if len(matched.hated) > 0 {
+		comment += "\nExcluded as hated on ListenBrainz: " + strings.Join(matched.hated, ", ")
+	}
+
+	if repeatCount > 0 {
+		comment += fmt.Sprintf("\nExcluded for being in a recent playlist: %d", repeatCount)
+	}
+
+	comment += matched.notes(songs)
+
+	err := j.writePlaylist(g.Name, comment, songs)
+	if err != nil {
+		return err
+	}
+
+	if g.RepeatWindow > 0 && !j.DryRun {
+		saveHistory(j.Username, g.Name, pool.generated, g.RepeatWindow, songs)
+	}
+
+	return nil
+}
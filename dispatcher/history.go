@@ -0,0 +1,108 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/store"
+	"net/url"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const (
+	historyPrefix = "history/"
+
+	repeatExclude    = "exclude"
+	repeatDownweight = "downweight"
+	// How much the score of a recently generated track is multiplied by when down-weighting
+	repeatPenalty = 0.1
+)
+
+// The songs placed in a generated playlist on a single (local) day
+type historyDay struct {
+	Date    string   `json:"date"`
+	SongIds []string `json:"songIds"`
+}
+
+func historyKey(username, playlist string) string {
+	return fmt.Sprintf("%s%s/%s", historyPrefix, url.PathEscape(username), url.PathEscape(playlist))
+}
+
+func loadHistory(username, playlist string) []historyDay {
+	history := []historyDay{}
+
+	_, err := store.Get(historyKey(username, playlist), &history)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read history of playlist `%s` for user %s: %v", playlist, username, err))
+		return []historyDay{}
+	}
+
+	return history
+}
+
+// Whether day is within the last window days before now. Today is excluded, so regenerating
+// a playlist on the same day does not avoid the tracks it was just generated with
+func inWindow(day string, now time.Time, window int) bool {
+	today := now.Format(time.DateOnly)
+	oldest := now.AddDate(0, 0, -window).Format(time.DateOnly)
+	return day < today && day >= oldest
+}
+
+// Returns the songs placed in this generated playlist in the last window days
+func recentlyGenerated(username, playlist string, now time.Time, window int) map[string]bool {
+	songs := map[string]bool{}
+
+	for _, day := range loadHistory(username, playlist) {
+		if inWindow(day.Date, now, window) {
+			for _, id := range day.SongIds {
+				songs[id] = true
+			}
+		}
+	}
+
+	return songs
+}
+
+// Records the songs of today's generated playlist, and forgets days outside of the window
+func saveHistory(username, playlist string, now time.Time, window int, songs []*types.Track) {
+	today := historyDay{Date: now.Format(time.DateOnly), SongIds: make([]string, len(songs))}
+	for idx, song := range songs {
+		today.SongIds[idx] = song.ID
+	}
+
+	history := []historyDay{}
+	for _, day := range loadHistory(username, playlist) {
+		if inWindow(day.Date, now, window) {
+			history = append(history, day)
+		}
+	}
+
+	history = append(history, today)
+
+	err := store.Set(historyKey(username, playlist), history)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save history of playlist `%s` for user %s: %v", playlist, username, err))
+	}
+}
+
+// Excludes or down-weights the songs that were placed in this playlist recently. Returns the number of songs excluded.
+// Down-weighted songs are kept, and not counted
+func (g *generationJob) avoidRepeats(songs []*types.Track, weights map[*types.Track]float64, recent map[string]bool) ([]*types.Track, int) {
+	if len(recent) == 0 {
+		return songs, 0
+	}
+
+	kept := []*types.Track{}
+
+	for _, song := range songs {
+		if !recent[song.ID] {
+			kept = append(kept, song)
+		} else if g.RepeatMode == repeatDownweight {
+			weights[song] *= repeatPenalty
+			kept = append(kept, song)
+		}
+	}
+
+	return kept, len(songs) - len(kept)
+}
@@ -0,0 +1,236 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"maps"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+)
+
+const (
+	ledgerPrefix = "ledger/"
+	// Not a real job. Used to record the decisions made by InitialFetch
+	initialFetch JobType = "initial-fetch"
+)
+
+const (
+	statusSuccess  = "success"
+	statusFailed   = "failed"
+	statusRetrying = "retrying"
+	statusQueued   = "queued"
+	statusSkipped  = "skipped"
+)
+
+type ledgerEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	JobType    JobType   `json:"jobType"`
+	Username   string    `json:"username"`
+	Playlist   string    `json:"playlist"`
+	LbzId      string    `json:"lbzId,omitempty"`
+	Status     string    `json:"status"`
+	Message    string    `json:"message,omitempty"`
+	Matched    int       `json:"matched"`
+	Missing    int       `json:"missing"`
+	Excluded   int       `json:"excluded"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	ErrorKind  string    `json:"errorKind,omitempty"`
+}
+
+// Track counts and outcome of a single playlist in a run
+type playlistStats struct {
+	message  string
+	matched  int
+	missing  int
+	excluded int
+}
+
+// Statistics about a single run of a job, used to populate the ledger
+type runStats struct {
+	lbzIds    map[string]string
+	errors    map[string]error
+	skipped   map[string]string
+	playlists map[string]*playlistStats
+}
+
+func ledgerKey(username, playlist string, ts time.Time) string {
+	return fmt.Sprintf("%s%s/%s/%s", ledgerPrefix, url.PathEscape(username), url.PathEscape(playlist), ts.UTC().Format("20060102T150405.000000000"))
+}
+
+func writeLedger(entry ledgerEntry, retentionDays int) {
+	if retentionDays <= 0 {
+		return
+	}
+
+	key := ledgerKey(entry.Username, entry.Playlist, entry.Timestamp)
+	err := store.SetWithTTL(key, entry, time.Duration(retentionDays)*24*time.Hour)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to write ledger entry %s: %v", key, err))
+	}
+}
+
+// Records whether InitialFetch decided to refresh a playlist
+func recordDecision(username, playlist, status, message string, retentionDays int) {
+	writeLedger(ledgerEntry{
+		Timestamp: time.Now(),
+		JobType:   initialFetch,
+		Username:  username,
+		Playlist:  playlist,
+		Status:    status,
+		Message:   message,
+	}, retentionDays)
+}
+
+// Records the outcome of this job in the ledger, one entry per playlist touched
+func (j *Job) record(start time.Time, err *retry.Error) {
+	if j.LedgerRetention <= 0 {
+		return
+	}
+
+	base := ledgerEntry{
+		Timestamp:  start,
+		JobType:    j.JobType,
+		Username:   j.Username,
+		DurationMs: time.Since(start).Milliseconds(),
+		Status:     statusSuccess,
+	}
+
+	if err != nil {
+		base.Error = err.Error.Error()
+		base.Status = statusFailed
+		if err.Kind != retry.Unknown {
+			base.ErrorKind = err.Kind.String()
+		}
+		if err.Retryable {
+			base.Status = statusRetrying
+		}
+	}
+
+	names := []string{}
+
+	switch j.JobType {
+	case FetchPatches:
+		// Sources can be imported under several names, which are only known once the playlists are fetched
+		names = j.stats.names()
+		if len(names) == 0 && j.Patch != nil {
+			for _, source := range j.Patch.Sources {
+				names = append(names, source.PlaylistName)
+			}
+		}
+	case GenerateJams:
+		for _, generate := range j.Generated {
+			names = append(names, generate.Name)
+		}
+	case ImportPlaylist:
+		if j.Import != nil {
+			names = append(names, j.Import.Name)
+		}
+	case TopTracks:
+		if j.Top != nil {
+			names = append(names, j.Top.Name)
+		}
+	case FreshReleases:
+		if j.Fresh != nil {
+			names = append(names, j.Fresh.Name)
+		}
+	case Radio:
+		if j.Radio != nil {
+			names = append(names, j.Radio.Name)
+		}
+	case Mirror, Rematch:
+		names = j.stats.names()
+	}
+
+	for _, name := range names {
+		entry := base
+		entry.Playlist = name
+		entry.LbzId = j.stats.lbzIds[name]
+
+		if stats, ok := j.stats.playlists[name]; ok {
+			entry.Message = stats.message
+			entry.Matched = stats.matched
+			entry.Missing = stats.missing
+			entry.Excluded = stats.excluded
+		}
+
+		// A patch fetch can fail for one source while still queueing imports for the others
+		if playlistErr, ok := j.stats.errors[name]; ok {
+			entry.Status = statusFailed
+			entry.Error = playlistErr.Error()
+			entry.ErrorKind = ""
+		} else if reason, ok := j.stats.skipped[name]; ok {
+			entry.Status = statusSkipped
+			entry.Message = reason
+			entry.Error = ""
+			entry.ErrorKind = ""
+		} else if (j.JobType == FetchPatches || j.JobType == Mirror) && entry.LbzId != "" {
+			entry.Status = statusQueued
+			entry.Error = ""
+		}
+
+		writeLedger(entry, j.LedgerRetention)
+	}
+}
+
+func (s *runStats) playlist(name string) *playlistStats {
+	if s.playlists == nil {
+		s.playlists = map[string]*playlistStats{}
+	}
+
+	stats, ok := s.playlists[name]
+	if !ok {
+		stats = &playlistStats{}
+		s.playlists[name] = stats
+	}
+
+	return stats
+}
+
+// The names of all playlists touched in this run, sorted
+func (s *runStats) names() []string {
+	names := map[string]bool{}
+
+	for name := range s.lbzIds {
+		names[name] = true
+	}
+	for name := range s.errors {
+		names[name] = true
+	}
+	for name := range s.skipped {
+		names[name] = true
+	}
+	for name := range s.playlists {
+		names[name] = true
+	}
+
+	return slices.Sorted(maps.Keys(names))
+}
+
+func (s *runStats) setLbzId(playlist, lbzId string) {
+	if s.lbzIds == nil {
+		s.lbzIds = map[string]string{}
+	}
+
+	s.lbzIds[playlist] = lbzId
+}
+
+func (s *runStats) setSkipped(playlist, reason string) {
+	if s.skipped == nil {
+		s.skipped = map[string]string{}
+	}
+
+	s.skipped[playlist] = reason
+}
+
+func (s *runStats) setError(playlist string, err error) {
+	if s.errors == nil {
+		s.errors = map[string]error{}
+	}
+
+	s.errors[playlist] = err
+}
@@ -0,0 +1,318 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/subsonic"
+	"strings"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+// How a track was matched
+type matchKind int
+
+const (
+	// Matched by the Navidrome matcher
+	matchExact matchKind = iota
+	// Substituted with a track by the same artist
+	matchFallback
+	// Matched to the song configured for the recording
+	matchOverridden
+	// Configured to never be matched
+	matchNever
+)
+
+// Matches tracks against the library of the user. Recordings with a match override skip the matcher,
+// and among several copies of a recording the preferred version is picked, if configured.
+// Any track which could not be matched directly is substituted with a track by the same artist, if enabled.
+// The second return value is how each track was matched, by index, for any track not matched by the matcher
+func (j *Job) matchTracks(tracks []types.SongRef) ([]*types.Track, map[int]matchKind, *retry.Error) {
+	return j.matchTracksUpTo(tracks, len(tracks))
+}
+
+// Like matchTracks, but fallback substitutes are only searched for until needed tracks are matched.
+// Pools of generated playlists are much larger than the playlists, so most missing tracks need no substitute
+func (j *Job) matchTracksUpTo(tracks []types.SongRef, needed int) ([]*types.Track, map[int]matchKind, *retry.Error) {
+	matches := make([]*types.Track, len(tracks))
+	kinds := map[int]matchKind{}
+	refs := []types.SongRef{}
+	indices := []int{}
+
+	for idx, track := range tracks {
+		songId, overridden := j.Overrides[track.MBID]
+		if !overridden || track.MBID == "" {
+			refs = append(refs, track)
+			indices = append(indices, idx)
+			continue
+		}
+
+		if songId == "" {
+			kinds[idx] = matchNever
+			continue
+		}
+
+		song, err := subsonic.GetSong(j.Username, songId)
+		if err != nil {
+			if err.Retryable {
+				return nil, nil, err
+			}
+
+			pdk.Log(pdk.LogWarn, fmt.Sprintf("Song %s overriding recording %s for user %s could not be fetched, matching the recording instead: %v", songId, track.MBID, j.Username, err.Error))
+			refs = append(refs, track)
+			indices = append(indices, idx)
+			continue
+		}
+
+		matches[idx] = song.ToTrack()
+		kinds[idx] = matchOverridden
+	}
+
+	if len(refs) > 0 {
+		found, err := j.matchSongs(refs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i, idx := range indices {
+			if i < len(found) {
+				matches[idx] = found[i]
+			}
+		}
+	}
+
+	if j.FallbackCount <= 0 {
+		return matches, kinds, nil
+	}
+
+	handler := subsonic.NewSubsonicHandler(j.FallbackCount)
+	used := map[string]bool{}
+
+	for _, song := range matches {
+		if song != nil {
+			used[song.ID] = true
+		}
+	}
+
+	for idx, song := range matches {
+		if len(used) >= needed {
+			break
+		}
+
+		if song != nil || kinds[idx] == matchNever {
+			continue
+		}
+
+		artistMbids := []string{}
+		for _, artist := range tracks[idx].Artists {
+			if artist.MBID != "" {
+				artistMbids = append(artistMbids, artist.MBID)
+			}
+		}
+
+		if len(artistMbids) == 0 {
+			continue
+		}
+
+		fallback, err := handler.FindFallback(j.Username, artistMbids, used)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if fallback != nil {
+			pdk.Log(pdk.LogDebug, fmt.Sprintf("Substituting `%s` with `%s` by %s", tracks[idx].Name, fallback.Title, fallback.Artist))
+			matches[idx] = fallback
+			kinds[idx] = matchFallback
+			used[fallback.ID] = true
+		}
+	}
+
+	return matches, kinds, nil
+}
+
+// The tracks of a playlist once matched and filtered: the songs to add, and why every other track was left out
+type matchedTracks struct {
+	// The songs to add, in the order of the tracks, and the index of the track each one was matched for
+	songs   []*types.Track
+	origins map[*types.Track]int
+	// Comment labels of the tracks left out, by reason
+	missing  []string
+	excluded []string
+	hated    []string
+	never    []string
+	// The missing tracks, by position among the songs
+	gaps   []gapTrack
+	report []reportTrack
+
+	tracks   []types.SongRef
+	creators []string
+	kinds    map[int]matchKind
+}
+
+// Sorts matched tracks into the songs to add and the tracks left out: missing, never matched by override,
+// hated on ListenBrainz, excluded by ratings or matched to a song already added.
+// Tracks are labelled by title and creator, or by title alone without creators, as in generated playlists
+func (j *Job) filterMatches(tracks []types.SongRef, creators []string, matches []*types.Track, kinds map[int]matchKind, feedback map[string]int, ratings map[int32]bool) *matchedTracks {
+	m := &matchedTracks{
+		songs:    []*types.Track{},
+		origins:  map[*types.Track]int{},
+		missing:  []string{},
+		excluded: []string{},
+		hated:    []string{},
+		never:    []string{},
+		gaps:     []gapTrack{},
+		report:   make([]reportTrack, len(tracks)),
+		tracks:   tracks,
+		creators: creators,
+		kinds:    kinds,
+	}
+	added := map[string]bool{}
+
+	for idx, song := range matches {
+		m.report[idx] = newReportTrack(tracks[idx], m.creator(idx))
+
+		switch {
+		case song == nil && kinds[idx] == matchNever:
+			m.never = append(m.never, m.trackLabel(idx))
+			m.report[idx].set(trackNeverMatched, nil)
+		case song == nil:
+			label := m.trackLabel(idx)
+			m.missing = append(m.missing, label)
+			m.gaps = append(m.gaps, gapTrack{Index: len(m.songs), Label: label, Ref: tracks[idx]})
+			m.report[idx].set(trackMissing, nil)
+		case j.isHated(feedback, feedbackMBID(tracks[idx], song, kinds[idx])):
+			m.hated = append(m.hated, m.songLabel(song))
+			m.report[idx].set(trackHated, song)
+		case !ratings[song.Rating]:
+			m.excluded = append(m.excluded, m.songLabel(song))
+			m.report[idx].set(trackExcluded, song)
+		case added[song.ID]:
+			m.report[idx].set(trackDuplicate, song)
+		default:
+			added[song.ID] = true
+			m.songs = append(m.songs, song)
+			m.origins[song] = idx
+			m.report[idx].set(addedStatus(kinds[idx]), song)
+		}
+	}
+
+	return m
+}
+
+func (m *matchedTracks) creator(idx int) string {
+	if m.creators == nil {
+		return ""
+	}
+
+	return m.creators[idx]
+}
+
+func (m *matchedTracks) trackLabel(idx int) string {
+	if m.creators == nil {
+		return m.tracks[idx].Name
+	}
+
+	return fmt.Sprintf("%s by %s", m.tracks[idx].Name, m.creators[idx])
+}
+
+func (m *matchedTracks) songLabel(song *types.Track) string {
+	if m.creators == nil {
+		return song.Title
+	}
+
+	return fmt.Sprintf("%s by %s", song.Title, song.Artist)
+}
+
+// The comment of an imported playlist: header, followed by the tracks left out and how the songs were matched
+func (m *matchedTracks) comment(header string) string {
+	comment := header
+
+	if len(m.missing) > 0 {
+		comment += "\nTracks not matched " + strings.Join(m.missing, ", ")
+	}
+
+	if len(m.excluded) > 0 {
+		comment += "\nTracks excluded by rating rule: " + strings.Join(m.excluded, ", ")
+	}
+
+	if len(m.hated) > 0 {
+		comment += "\nTracks excluded as hated on ListenBrainz: " + strings.Join(m.hated, ", ")
+	}
+
+	return comment + m.notes(m.songs)
+}
+
+// The comment lines listing which of songs were substituted or overridden, and the tracks never matched by override
+func (m *matchedTracks) notes(songs []*types.Track) string {
+	substituted := []string{}
+	overridden := []string{}
+
+	for _, song := range songs {
+		idx := m.origins[song]
+		label := fmt.Sprintf("%s (for %s)", m.songLabel(song), m.trackLabel(idx))
+
+		switch m.kinds[idx] {
+		case matchFallback:
+			substituted = append(substituted, label)
+		case matchOverridden:
+			overridden = append(overridden, label)
+		}
+	}
+
+	comment := ""
+
+	if len(substituted) > 0 {
+		comment += "\nFallback substitutions: " + strings.Join(substituted, ", ")
+	}
+
+	if len(overridden) > 0 {
+		comment += "\nMatched by override: " + strings.Join(overridden, ", ")
+	}
+
+	if len(m.never) > 0 {
+		comment += "\nNever matched by override: " + strings.Join(m.never, ", ")
+	}
+
+	return comment
+}
+
+// Matches tracks against the library and writes the songs passing the filters to the playlist name.
+// The comment starts with header, followed by the tracks that were not matched or excluded.
+// Returns whether the playlist was written
+func (j *Job) writeMatchedPlaylist(name, header string, tracks []types.SongRef, creators []string) (bool, *retry.Error) {
+	matches, kinds, err := j.matchTracks(tracks)
+	if err != nil {
+		return false, err
+	}
+
+	feedback, err := j.loadFeedback()
+	if err != nil {
+		return false, err
+	}
+
+	matched := j.filterMatches(tracks, creators, matches, kinds, feedback, j.Ratings)
+	j.saveReport(name, matched.report)
+
+	stats := j.stats.playlist(name)
+	stats.matched = len(matched.songs)
+	stats.missing = len(matched.missing)
+	stats.excluded = len(matched.excluded) + len(matched.hated) + len(matched.never)
+
+	if len(matched.songs) == 0 {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("No matching files found for playlist %s. Refusing to create/update", name))
+		stats.message = "no matching files found, playlist not updated"
+		return false, nil
+	}
+
+	comment := matched.comment(header)
+
+	err = j.writePlaylist(name, comment, matched.songs)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to write playlist `%s` for user %s: %v", name, j.Username, err.Error))
+		return false, err
+	}
+
+	j.saveGaps(name, comment, matched.songs, matched.gaps)
+	return true, nil
+}
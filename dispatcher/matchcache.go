@@ -0,0 +1,185 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"net/url"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const matchCachePrefix = "matches/"
+
+type matchEntry struct {
+	// The ID of the matched song, empty if the recording is not in the library
+	SongID   string `json:"songId,omitempty"`
+	CachedAt int64  `json:"cachedAt"`
+}
+
+// The songs recordings were last matched to for a user, by recording MBID.
+// Matches are cached once the preferred version is picked, so cached matches are not compared again
+type matchCache struct {
+	// The most recent library scan when the cache was saved. Any later scan invalidates the whole cache
+	ScannedAt int64 `json:"scannedAt"`
+	// The version preference the matches were picked with. Changing it invalidates the whole cache
+	Preference *versionPreference    `json:"preference,omitempty"`
+	Entries    map[string]matchEntry `json:"entries"`
+}
+
+func matchCacheKey(username string) string {
+	return matchCachePrefix + url.PathEscape(username)
+}
+
+// The time of the most recent scan across all libraries
+func lastLibraryScan() (int64, error) {
+	libraries, err := host.LibraryGetAllLibraries()
+	if err != nil {
+		return 0, err
+	}
+
+	var scannedAt int64
+	for _, library := range libraries {
+		scannedAt = max(scannedAt, library.LastScanAt)
+	}
+
+	return scannedAt, nil
+}
+
+// Loads the match cache of a user, without any entry cached before expiry.
+// A cache saved before the last library scan, or with another version preference, is discarded
+func loadMatchCache(username string, scannedAt, expiry int64, preference *versionPreference) *matchCache {
+	cache := &matchCache{}
+
+	_, err := store.Get(matchCacheKey(username), cache)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read match cache of user %s: %v", username, err))
+	}
+
+	if err != nil || cache.ScannedAt < scannedAt || cache.Entries == nil || !preference.equal(cache.Preference) {
+		return &matchCache{ScannedAt: scannedAt, Preference: preference, Entries: map[string]matchEntry{}}
+	}
+
+	for mbid, entry := range cache.Entries {
+		if entry.CachedAt < expiry {
+			delete(cache.Entries, mbid)
+		}
+	}
+
+	return cache
+}
+
+func saveMatchCache(username string, cache *matchCache, ttl time.Duration) {
+	err := store.SetWithTTL(matchCacheKey(username), cache, ttl)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save match cache of user %s: %v", username, err))
+	}
+}
+
+// Matches tracks against the library of the user, picking the preferred version of each match, and remembering the match
+// of each recording MBID for MatchCacheHours. Recordings cached as missing are not matched again, and cached matches
+// are fetched by song ID without going through the matcher, so they still have up-to-date ratings.
+// Cached matches are forgotten after any library scan
+func (j *Job) matchSongs(tracks []types.SongRef) ([]*types.Track, *retry.Error) {
+	if j.MatchCacheHours <= 0 {
+		return j.matchVersions(tracks)
+	}
+
+	scannedAt, err := lastLibraryScan()
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to fetch libraries, not using the match cache: %v", err))
+		return j.matchVersions(tracks)
+	}
+
+	ttl := time.Duration(j.MatchCacheHours) * time.Hour
+	now := time.Now()
+	cache := loadMatchCache(j.Username, scannedAt, now.Add(-ttl).Unix(), j.Preference)
+
+	matches := make([]*types.Track, len(tracks))
+	refs := []types.SongRef{}
+	indices := []int{}
+	cachedMatches, cachedMissing := 0, 0
+
+	for idx, track := range tracks {
+		entry, cached := cache.Entries[track.MBID]
+		cached = cached && track.MBID != ""
+
+		if cached && entry.SongID == "" {
+			cachedMissing++
+			continue
+		}
+
+		if cached {
+			song, err := subsonic.GetSong(j.Username, entry.SongID)
+			if err == nil {
+				matches[idx] = song.ToTrack()
+				cachedMatches++
+				continue
+			}
+
+			if err.Retryable {
+				return nil, err
+			}
+
+			pdk.Log(pdk.LogWarn, fmt.Sprintf("Song %s cached for recording %s for user %s could not be fetched, matching the recording again: %v", entry.SongID, track.MBID, j.Username, err.Error))
+		}
+
+		refs = append(refs, track)
+		indices = append(indices, idx)
+	}
+
+	pdk.Log(pdk.LogDebug, fmt.Sprintf("Matching %d tracks for user %s: %d cached matches, %d cached as missing", len(tracks), j.Username, cachedMatches, cachedMissing))
+
+	if len(refs) > 0 {
+		songs, err := j.matchVersions(refs)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, idx := range indices {
+			if i < len(songs) {
+				matches[idx] = songs[i]
+			}
+
+			mbid := tracks[idx].MBID
+			if mbid == "" {
+				continue
+			}
+
+			entry := matchEntry{CachedAt: now.Unix()}
+			if matches[idx] != nil {
+				entry.SongID = matches[idx].ID
+			}
+
+			// An unchanged match keeps its age, so it is still checked again once it expires
+			if old, ok := cache.Entries[mbid]; !ok || old.SongID != entry.SongID {
+				cache.Entries[mbid] = entry
+			}
+		}
+	}
+
+	saveMatchCache(j.Username, cache, ttl)
+	return matches, nil
+}
+
+// Matches tracks with the Navidrome matcher, replacing each match with the preferred version of the recording
+func (j *Job) matchVersions(tracks []types.SongRef) ([]*types.Track, *retry.Error) {
+	songs, err := host.MatcherMatchSongs(tracks, host.MatchOptions{Username: j.Username})
+	if err != nil {
+		return nil, &retry.Error{Error: err, Retryable: false}
+	}
+
+	matches := make([]*types.Track, len(tracks))
+	copy(matches, songs)
+
+	retryErr := j.preferVersions(tracks, matches)
+	if retryErr != nil {
+		return nil, retryErr
+	}
+
+	return matches, nil
+}
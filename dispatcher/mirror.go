@@ -0,0 +1,226 @@
+package dispatcher
+
+import (
+	"errors"
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+)
+
+const (
+	mirrorPrefix = "mirror/"
+
+	defaultMirrorTemplate = "{title}"
+)
+
+// The name of the Navidrome playlist each mirrored ListenBrainz playlist was imported as, by ListenBrainz playlist ID
+type mirrorState map[string]string
+
+func mirrorKey(username string) string {
+	return mirrorPrefix + url.PathEscape(username)
+}
+
+func loadMirrorState(username string) mirrorState {
+	state := mirrorState{}
+
+	_, err := store.Get(mirrorKey(username), &state)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read mirrored playlists of user %s: %v", username, err))
+		return mirrorState{}
+	}
+
+	return state
+}
+
+func saveMirrorState(username string, state mirrorState) {
+	err := store.Set(mirrorKey(username), state)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save mirrored playlists of user %s: %v", username, err))
+	}
+}
+
+// The Navidrome name of a mirrored playlist: the template, with {title} and {creator} replaced by those of the playlist
+func mirrorName(template string, playlist *listenbrainz.LbzPlaylist) string {
+	if template == "" {
+		template = defaultMirrorTemplate
+	}
+
+	return strings.NewReplacer("{title}", playlist.Title, "{creator}", playlist.Creator).Replace(template)
+}
+
+// Lists the playlists the user created or collaborates on, and queues an import for each one changed since it was last imported.
+// Playlists are tracked by ListenBrainz ID, so a playlist renamed upstream is renamed locally.
+// Playlists deleted upstream are forgotten, and removed locally if enabled
+func (j *Job) dispatchMirror() *retry.Error {
+	if j.Mirror == nil {
+		return retry.FatalError("attempting to call mirror job without mirror payload")
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Mirroring ListenBrainz playlists of %s for user %s", j.LbzUsername, j.Username))
+
+	playlists, err := listenbrainz.GetUserPlaylists(j.LbzUsername, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to list playlists for user %s: %v", j.Username, err.Error))
+		return err
+	}
+
+	var ignoredError error = nil
+	previous := loadMirrorState(j.Username)
+	current := mirrorState{}
+	// The ListenBrainz playlist mirrored to each Navidrome playlist name
+	claimed := map[string]string{}
+	existing := lazyPlaylists(j.Username)
+
+	// The other playlists of the user are claimed by no ListenBrainz playlist, so they are never renamed or removed
+	for _, name := range j.Mirror.Reserved {
+		claimed[name] = ""
+	}
+
+	for _, playlist := range playlists {
+		lbzId := listenbrainz.GetIdentifier(playlist.Identifier)
+		name := mirrorName(j.Mirror.NameTemplate, playlist)
+
+		if other, ok := claimed[name]; ok && other == "" {
+			pdk.Log(pdk.LogWarn, fmt.Sprintf("Not mirroring ListenBrainz playlist %s as `%s` for user %s, as another playlist of the user has that name", lbzId, name, j.Username))
+			j.stats.setSkipped(name, "name used by another playlist of this user")
+			continue
+		} else if ok {
+			newErr := fmt.Errorf("ListenBrainz playlists %s and %s would both be mirrored as `%s`, only mirroring the first", other, lbzId, name)
+			ignoredError = errors.Join(ignoredError, newErr)
+			j.stats.setError(name, newErr)
+			pdk.Log(pdk.LogError, newErr.Error())
+			continue
+		}
+
+		claimed[name] = lbzId
+		current[lbzId] = name
+	}
+
+	for _, playlist := range playlists {
+		lbzId := listenbrainz.GetIdentifier(playlist.Identifier)
+		name, ok := current[lbzId]
+		if !ok {
+			continue
+		}
+
+		if oldName, mirrored := previous[lbzId]; mirrored && oldName != name {
+			err := j.renameMirrored(oldName, name, claimed, existing)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := j.queueImport(name, playlist, existing)
+		if err != nil {
+			if err.Retryable {
+				return err
+			}
+			ignoredError = errors.Join(ignoredError, err.Error)
+		}
+	}
+
+	for _, lbzId := range slices.Sorted(maps.Keys(previous)) {
+		if _, ok := current[lbzId]; !ok {
+			err := j.forgetMirrored(previous[lbzId], claimed, existing)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !j.DryRun {
+		saveMirrorState(j.Username, current)
+	}
+
+	if ignoredError != nil {
+		return &retry.Error{Error: ignoredError, Retryable: false}
+	}
+
+	return nil
+}
+
+// Renames the Navidrome playlist of a mirrored playlist renamed upstream, along with its import state.
+// If either name belongs to another playlist, nothing is renamed and the playlist is imported under its new name
+func (j *Job) renameMirrored(oldName, name string, claimed map[string]string, existing func() *subsonic.JsonWrapper) *retry.Error {
+	resp := existing()
+	if resp == nil {
+		return nil
+	}
+
+	local := subsonic.FindExistingPlaylist(resp, oldName)
+	if local == nil {
+		return nil
+	}
+
+	if _, ok := claimed[oldName]; ok || subsonic.FindExistingPlaylist(resp, name) != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Not renaming playlist `%s` to `%s` for user %s, as the name is already in use", oldName, name, j.Username))
+		return nil
+	}
+
+	j.stats.playlist(name).message = fmt.Sprintf("renamed from `%s`", oldName)
+
+	if j.DryRun {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Dry run: would rename playlist `%s` to `%s` for user %s", oldName, name, j.Username))
+		return nil
+	}
+
+	err := subsonic.RenamePlaylist(j.Username, local.Id, name)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to rename playlist `%s` to `%s` for user %s: %v", oldName, name, j.Username, err.Error))
+		return err
+	}
+
+	if state := loadPlaylistState(j.Username, oldName); state != nil {
+		savePlaylistState(j.Username, name, *state)
+		deletePlaylistState(j.Username, oldName)
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Renamed playlist `%s` to `%s` for user %s", oldName, name, j.Username))
+	return nil
+}
+
+// Stops mirroring a playlist deleted upstream, deleting the Navidrome playlist if enabled.
+// A playlist whose name is now mirrored from another ListenBrainz playlist is left to that playlist
+func (j *Job) forgetMirrored(name string, claimed map[string]string, existing func() *subsonic.JsonWrapper) *retry.Error {
+	if _, ok := claimed[name]; ok {
+		return nil
+	}
+
+	stats := j.stats.playlist(name)
+
+	if !j.Mirror.Delete {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("ListenBrainz playlist of `%s` for user %s was deleted, no longer mirroring it", name, j.Username))
+		stats.message = "deleted on ListenBrainz, no longer mirrored"
+		return nil
+	}
+
+	if j.DryRun {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Dry run: would delete playlist `%s` for user %s", name, j.Username))
+		stats.message = "deleted on ListenBrainz, would be removed"
+		return nil
+	}
+
+	if resp := existing(); resp != nil {
+		if local := subsonic.FindExistingPlaylist(resp, name); local != nil {
+			err := subsonic.DeletePlaylist(j.Username, local.Id)
+			if err != nil {
+				pdk.Log(pdk.LogError, fmt.Sprintf("Failed to delete playlist `%s` for user %s: %v", name, j.Username, err.Error))
+				return err
+			}
+		}
+	}
+
+	deletePlaylistState(j.Username, name)
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("ListenBrainz playlist of `%s` for user %s was deleted, removed it", name, j.Username))
+	stats.message = "deleted on ListenBrainz, removed"
+	return nil
+}
@@ -0,0 +1,35 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+)
+
+// The LB Radio modes, from the most to the least similar to the prompt
+var radioModes = []string{"easy", "medium", "hard"}
+
+// Generates a playlist from an LB Radio prompt and imports it like a ListenBrainz playlist
+func (j *Job) dispatchRadio() *retry.Error {
+	if j.Radio == nil {
+		return retry.FatalError("attempting to call radio job without radio payload")
+	}
+
+	name := j.Radio.Name
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Generating radio playlist `%s` for user %s from prompt `%s` (%s)", name, j.Username, j.Radio.Prompt, j.Radio.Mode))
+
+	playlist, err := listenbrainz.GetRadioPlaylist(j.Radio.Prompt, j.Radio.Mode, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to generate radio playlist `%s` for user %s: %v", name, j.Username, err.Error))
+		return err
+	}
+
+	header := fmt.Sprintf("Generated by LB Radio from prompt `%s` (mode %s)\nUpdated on: %s", j.Radio.Prompt, j.Radio.Mode, time.Now().Format(time.RFC3339))
+
+	_, err = j.writeLbzPlaylist(name, header, playlist)
+	return err
+}
@@ -0,0 +1,348 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const (
+	gapsPrefix = "gaps/"
+	// The last library scan for which missing tracks were rematched
+	rematchScanKey = "rematch/scannedAt"
+
+	notMatchedPrefix = "\nTracks not matched "
+	rematchedPrefix  = "\nMatched after a library scan: "
+)
+
+// A track of a playlist which was not found in the library
+type gapTrack struct {
+	// The number of playlist songs before this track
+	Index int `json:"index"`
+	// The track, as listed in the playlist comment
+	Label string        `json:"label"`
+	Ref   types.SongRef `json:"ref"`
+}
+
+// The missing tracks of a playlist, along with the playlist as it was written
+type playlistGaps struct {
+	// The most recent library scan when the tracks were last matched
+	ScannedAt int64      `json:"scannedAt"`
+	Comment   string     `json:"comment"`
+	Songs     []string   `json:"songs"`
+	Tracks    []gapTrack `json:"tracks"`
+}
+
+func gapsUserPrefix(username string) string {
+	return gapsPrefix + url.PathEscape(username) + "/"
+}
+
+func gapsKey(username, playlist string) string {
+	return gapsUserPrefix(username) + url.PathEscape(playlist)
+}
+
+func songIds(songs []*types.Track) []string {
+	ids := make([]string, len(songs))
+	for idx, song := range songs {
+		ids[idx] = song.ID
+	}
+	return ids
+}
+
+// Remembers the missing tracks of a playlist that was just written, so they can be added once they are in the library
+func (j *Job) saveGaps(name, comment string, songs []*types.Track, gaps []gapTrack) {
+	if !j.RematchMissing || j.DryRun {
+		return
+	}
+
+	key := gapsKey(j.Username, name)
+
+	if len(gaps) == 0 {
+		if err := store.Delete(key); err != nil {
+			pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to delete missing tracks %s: %v", key, err))
+		}
+		return
+	}
+
+	scannedAt, err := lastLibraryScan()
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to fetch libraries, missing tracks of `%s` will be matched after the next scan: %v", name, err))
+	}
+
+	state := playlistGaps{
+		ScannedAt: scannedAt,
+		Comment:   comment,
+		Songs:     songIds(songs),
+		Tracks:    gaps,
+	}
+
+	if err := store.Set(key, state); err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save missing tracks %s: %v", key, err))
+	}
+}
+
+// Rewrites the comment of a playlist once some of its missing tracks were added
+func rematchedComment(comment string, missing, remaining, added []string) string {
+	line := ""
+	if len(remaining) > 0 {
+		line = notMatchedPrefix + strings.Join(remaining, ", ")
+	}
+
+	comment = strings.Replace(comment, notMatchedPrefix+strings.Join(missing, ", "), line, 1)
+
+	if strings.Contains(comment, rematchedPrefix) {
+		return comment + ", " + strings.Join(added, ", ")
+	}
+
+	return comment + rematchedPrefix + strings.Join(added, ", ")
+}
+
+// Queues a job to rematch the missing tracks of every user who has some, if a library scan completed since the last check
+func CheckMissing() error {
+	scannedAt, err := lastLibraryScan()
+	if err != nil {
+		return fmt.Errorf("unable to fetch libraries: %v", err)
+	}
+
+	var checked int64
+	_, err = store.Get(rematchScanKey, &checked)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read the last rematched library scan: %v", err))
+	}
+
+	if scannedAt <= checked {
+		pdk.Log(pdk.LogDebug, "No library scan since missing tracks were last rematched")
+		return nil
+	}
+
+	users, err := GetConfig()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		keys, err := host.KVStoreList(gapsUserPrefix(user.NDUsername))
+		if err != nil {
+			return err
+		}
+
+		if len(keys) == 0 {
+			continue
+		}
+
+		// Missing tracks are only stored while rematching is enabled, so the remaining ones are kept up to date even if it was since disabled
+		job := userJob(user, Rematch)
+		job.RematchMissing = true
+		// Fallback substitutes are never added to the gaps, so they are not searched for
+		job.FallbackCount = 0
+
+		payload, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Library scanned, rematching missing tracks of %d playlist(s) for user %s", len(keys), user.NDUsername))
+
+		_, err = host.TaskEnqueue(queueName, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return store.Set(rematchScanKey, scannedAt)
+}
+
+// Matches the missing tracks of every playlist of the user again, adding the ones now in the library
+func (j *Job) dispatchRematch() *retry.Error {
+	scannedAt, err := lastLibraryScan()
+	if err != nil {
+		return &retry.Error{Error: fmt.Errorf("unable to fetch libraries: %v", err), Retryable: false}
+	}
+
+	prefix := gapsUserPrefix(j.Username)
+	keys, err := host.KVStoreList(prefix)
+	if err != nil {
+		return &retry.Error{Error: err, Retryable: false}
+	}
+
+	var ignoredError error = nil
+
+	for _, key := range keys {
+		name, unescapeErr := url.PathUnescape(strings.TrimPrefix(key, prefix))
+		if unescapeErr != nil {
+			continue
+		}
+
+		rematchErr := j.rematchPlaylist(name, key, scannedAt)
+		if rematchErr != nil {
+			if rematchErr.Retryable {
+				return rematchErr
+			}
+
+			j.stats.setError(name, rematchErr.Error)
+			ignoredError = errors.Join(ignoredError, rematchErr.Error)
+		}
+	}
+
+	if ignoredError != nil {
+		return &retry.Error{Error: ignoredError, Retryable: false}
+	}
+
+	return nil
+}
+
+// Adds the missing tracks of a playlist now in the library at their original positions.
+// If the playlist was changed since it was written, its missing tracks are forgotten instead
+func (j *Job) rematchPlaylist(name, key string, scannedAt int64) *retry.Error {
+	var gaps playlistGaps
+
+	ok, storeErr := store.Get(key, &gaps)
+	if storeErr != nil || !ok {
+		return nil
+	}
+
+	if gaps.ScannedAt >= scannedAt {
+		return nil
+	}
+
+	current, err := subsonic.GetPlaylistEntries(j.Username, name)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(current))
+	for idx, song := range current {
+		ids[idx] = song.Id
+	}
+
+	if current == nil || !slices.Equal(ids, gaps.Songs) {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Playlist `%s` of user %s changed since it was written, no longer rematching its missing tracks", name, j.Username))
+		j.stats.setSkipped(name, "playlist changed since it was written")
+
+		if !j.DryRun {
+			if err := store.Delete(key); err != nil {
+				pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to delete missing tracks %s: %v", key, err))
+			}
+		}
+		return nil
+	}
+
+	refs := make([]types.SongRef, len(gaps.Tracks))
+	for idx, gap := range gaps.Tracks {
+		refs[idx] = gap.Ref
+	}
+
+	matches, kinds, err := j.matchTracks(refs)
+	if err != nil {
+		return err
+	}
+
+	feedback, err := j.loadFeedback()
+	if err != nil {
+		return err
+	}
+
+	present := map[string]bool{}
+	for _, id := range ids {
+		present[id] = true
+	}
+
+	found := map[int]*types.Track{}
+	for idx, song := range matches {
+		// Fallback substitutions would fill the gap with another recording
+		if song == nil || kinds[idx] == matchFallback || present[song.ID] || !j.Ratings[song.Rating] || j.isHated(feedback, feedbackMBID(refs[idx], song, kinds[idx])) {
+			continue
+		}
+
+		found[idx] = song
+		present[song.ID] = true
+	}
+
+	if len(found) == 0 {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("None of the %d missing tracks of `%s` for user %s are in the library yet", len(gaps.Tracks), name, j.Username))
+		j.stats.setSkipped(name, "no missing tracks found in the library")
+
+		if !j.DryRun {
+			gaps.ScannedAt = scannedAt
+			if err := store.Set(key, gaps); err != nil {
+				pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save missing tracks %s: %v", key, err))
+			}
+		}
+		return nil
+	}
+
+	songs := []*types.Track{}
+	remaining := []gapTrack{}
+	missing, stillMissing, added := []string{}, []string{}, []string{}
+	next := 0
+
+	// Places every gap which was before the song at position
+	place := func(position int) {
+		for ; next < len(gaps.Tracks) && gaps.Tracks[next].Index <= position; next++ {
+			gap := gaps.Tracks[next]
+			missing = append(missing, gap.Label)
+
+			if song, ok := found[next]; ok {
+				songs = append(songs, song)
+				added = append(added, gap.Label)
+				continue
+			}
+
+			gap.Index = len(songs)
+			remaining = append(remaining, gap)
+			stillMissing = append(stillMissing, gap.Label)
+		}
+	}
+
+	for position, song := range current {
+		place(position)
+		songs = append(songs, song.ToTrack())
+	}
+	place(math.MaxInt)
+
+	comment := rematchedComment(gaps.Comment, missing, stillMissing, added)
+
+	err = j.writePlaylist(name, comment, songs)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to add missing tracks to playlist `%s` for user %s: %v", name, j.Username, err.Error))
+		return err
+	}
+
+	stats := j.stats.playlist(name)
+	stats.matched = len(added)
+	stats.missing = len(remaining)
+	if !j.DryRun {
+		stats.message = fmt.Sprintf("added %d previously missing tracks", len(added))
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Added %d previously missing tracks to playlist `%s` for user %s", len(added), name, j.Username))
+
+	if j.DryRun {
+		return nil
+	}
+
+	if len(remaining) == 0 {
+		if err := store.Delete(key); err != nil {
+			pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to delete missing tracks %s: %v", key, err))
+		}
+		return nil
+	}
+
+	gaps = playlistGaps{ScannedAt: scannedAt, Comment: comment, Songs: songIds(songs), Tracks: remaining}
+	if err := store.Set(key, gaps); err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save missing tracks %s: %v", key, err))
+	}
+
+	return nil
+}
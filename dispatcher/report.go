@@ -0,0 +1,199 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/store"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const (
+	reportPrefix  = "report/"
+	missingPrefix = "missing/"
+
+	recordingUrl = "https://musicbrainz.org/recording/"
+
+	// Reports are replaced on every run, so only reports of playlists no longer synced with reports enabled expire
+	reportTTL = 30 * 24 * time.Hour
+)
+
+// What happened to a track of a playlist
+type matchStatus string
+
+const (
+	trackExact          matchStatus = "exact"
+	trackFallback       matchStatus = "fallback"
+	trackOverridden     matchStatus = "overridden"
+	trackExcluded       matchStatus = "excluded-by-rating"
+	trackHated          matchStatus = "hated"
+	trackPlayedRecently matchStatus = "played-recently"
+	trackNeverPlayed    matchStatus = "never-played"
+	trackDuplicate      matchStatus = "duplicate"
+	trackNotSelected    matchStatus = "not-selected"
+	trackNeverMatched   matchStatus = "never-matched"
+	trackMissing        matchStatus = "missing"
+)
+
+// The status of a track added to the playlist, by how it was matched
+func addedStatus(kind matchKind) matchStatus {
+	switch kind {
+	case matchFallback:
+		return trackFallback
+	case matchOverridden:
+		return trackOverridden
+	default:
+		return trackExact
+	}
+}
+
+type reportTrack struct {
+	// The position of the track among the tracks ListenBrainz provided, starting at 0
+	Position      int         `json:"position"`
+	Title         string      `json:"title"`
+	Creator       string      `json:"creator"`
+	Album         string      `json:"album,omitempty"`
+	DurationMs    uint32      `json:"durationMs,omitempty"`
+	RecordingMBID string      `json:"recordingMbid,omitempty"`
+	ReleaseMBID   string      `json:"releaseMbid,omitempty"`
+	ArtistMBIDs   []string    `json:"artistMbids,omitempty"`
+	Status        matchStatus `json:"status"`
+	SongID        string      `json:"songId,omitempty"`
+}
+
+type matchReport struct {
+	Timestamp time.Time `json:"timestamp"`
+	Username  string    `json:"username"`
+	Playlist  string    `json:"playlist"`
+	// The number of tracks added to the playlist. Only the other tracks are listed, to keep reports small
+	Added  int           `json:"added"`
+	Tracks []reportTrack `json:"tracks"`
+}
+
+type jspfTrack struct {
+	Identifier []string `json:"identifier"`
+	Title      string   `json:"title"`
+	Creator    string   `json:"creator"`
+	Album      string   `json:"album,omitempty"`
+	Duration   uint32   `json:"duration,omitempty"`
+}
+
+type jspfPlaylist struct {
+	Title      string      `json:"title"`
+	Creator    string      `json:"creator"`
+	Date       time.Time   `json:"date"`
+	Annotation string      `json:"annotation"`
+	Track      []jspfTrack `json:"track"`
+}
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+// Describes a source track, before it is matched. An empty creator is replaced by the artist names
+func newReportTrack(ref types.SongRef, creator string) reportTrack {
+	track := reportTrack{
+		Title:         ref.Name,
+		Creator:       creator,
+		Album:         ref.Album,
+		DurationMs:    ref.DurationMs,
+		RecordingMBID: ref.MBID,
+		ReleaseMBID:   ref.AlbumMBID,
+	}
+
+	names := []string{}
+	for _, artist := range ref.Artists {
+		names = append(names, artist.Name)
+		if artist.MBID != "" {
+			track.ArtistMBIDs = append(track.ArtistMBIDs, artist.MBID)
+		}
+	}
+
+	if track.Creator == "" {
+		track.Creator = strings.Join(names, ", ")
+	}
+
+	return track
+}
+
+// Records the outcome of a track, along with the song it was matched to, if any
+func (t *reportTrack) set(status matchStatus, song *types.Track) {
+	t.Status = status
+	if song != nil {
+		t.SongID = song.ID
+	}
+}
+
+// The missing tracks of the report, as a JSPF playlist
+func (r *matchReport) missingJspf() jspfDocument {
+	tracks := []jspfTrack{}
+
+	for _, track := range r.Tracks {
+		if track.Status != trackMissing {
+			continue
+		}
+
+		identifier := []string{}
+		if track.RecordingMBID != "" {
+			identifier = append(identifier, recordingUrl+track.RecordingMBID)
+		}
+
+		tracks = append(tracks, jspfTrack{
+			Identifier: identifier,
+			Title:      track.Title,
+			Creator:    track.Creator,
+			Album:      track.Album,
+			Duration:   track.DurationMs,
+		})
+	}
+
+	return jspfDocument{Playlist: jspfPlaylist{
+		Title:      fmt.Sprintf("%s: missing tracks", r.Playlist),
+		Creator:    r.Username,
+		Date:       r.Timestamp,
+		Annotation: fmt.Sprintf("Tracks of `%s` not found in the library of %s", r.Playlist, r.Username),
+		Track:      tracks,
+	}}
+}
+
+func reportKey(prefix, username, playlist string) string {
+	return fmt.Sprintf("%s%s/%s", prefix, url.PathEscape(username), url.PathEscape(playlist))
+}
+
+// Stores the match report of a playlist, with the tracks not added to it, and its missing tracks as JSPF,
+// if match reports are enabled
+func (j *Job) saveReport(name string, tracks []reportTrack) {
+	if !j.MatchReports {
+		return
+	}
+
+	report := matchReport{
+		Timestamp: time.Now(),
+		Username:  j.Username,
+		Playlist:  name,
+		Tracks:    []reportTrack{},
+	}
+
+	for idx, track := range tracks {
+		switch track.Status {
+		case trackExact, trackFallback, trackOverridden:
+			report.Added++
+		default:
+			track.Position = idx
+			report.Tracks = append(report.Tracks, track)
+		}
+	}
+
+	key := reportKey(reportPrefix, j.Username, name)
+	if err := store.SetWithTTL(key, report, reportTTL); err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to store match report %s: %v", key, err))
+	}
+
+	key = reportKey(missingPrefix, j.Username, name)
+	if err := store.SetWithTTL(key, report.missingJspf(), reportTTL); err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to store missing tracks %s: %v", key, err))
+	}
+}
@@ -0,0 +1,58 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+)
+
+const (
+	retryPrefix = "retry:"
+	// The maximum number of times a job will be rescheduled at a time hinted by ListenBrainz.
+	// After this, the job is retried using the queue backoff
+	maxScheduledRetries = 5
+)
+
+// Schedules a job to be enqueued again after delay (as hinted by Retry-After), instead of retrying with the queue backoff.
+// Returns false if the job has been rescheduled too many times, or could not be scheduled
+func ScheduleRetry(taskId string, job Job, delay time.Duration) bool {
+	if job.Retries >= maxScheduledRetries {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Job for user %s has been rescheduled %d times, falling back to queue backoff", job.Username, job.Retries))
+		return false
+	}
+
+	job.Retries += 1
+
+	payload, err := json.Marshal(job)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Error serializing job for retry: %v", err))
+		return false
+	}
+
+	delaySeconds := int32(max(math.Ceil(delay.Seconds()), 1))
+
+	_, err = host.SchedulerScheduleOneTime(delaySeconds, retryPrefix+string(payload), retryPrefix+taskId)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Failed to schedule retry, falling back to queue backoff: %v", err))
+		return false
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Retrying %s job for user %s in %d seconds", job.JobType, job.Username, delaySeconds))
+	return true
+}
+
+// Whether a scheduler callback payload is a job rescheduled by ScheduleRetry
+func IsRetry(payload string) bool {
+	return strings.HasPrefix(payload, retryPrefix)
+}
+
+// Enqueues a job rescheduled by ScheduleRetry
+func EnqueueRetry(payload string) error {
+	_, err := host.TaskEnqueue(queueName, []byte(strings.TrimPrefix(payload, retryPrefix)))
+	return err
+}
@@ -0,0 +1,228 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+)
+
+const schedulePrefix = "schedule/"
+
+// The name and allowed range of each field of a cron expression
+var cronFields = []struct {
+	name     string
+	min, max int
+	names    []string
+}{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// The payload of a per-playlist scheduler callback
+type scheduledPlaylist struct {
+	Username string `json:"username"`
+	Playlist string `json:"playlist"`
+}
+
+// Converts a playlist schedule into a cron expression. A schedule is either a cron expression,
+// or a cadence of `daily` or `weekly:<weekday>`, optionally followed by `@<hour>`.
+// Cadences without an hour run at defaultHour. Like the global schedule, cadences run at a random
+// minute of that hour. An empty schedule returns an empty expression
+func cronExpression(schedule string, defaultHour int) (string, error) {
+	schedule = strings.TrimSpace(schedule)
+	if schedule == "" {
+		return "", nil
+	}
+
+	if strings.ContainsAny(schedule, " \t") {
+		if err := validateCron(schedule); err != nil {
+			return "", err
+		}
+		return schedule, nil
+	}
+
+	cadence, hourString, hasHour := strings.Cut(strings.ToLower(schedule), "@")
+	hour := defaultHour
+
+	if hasHour {
+		parsed, err := strconv.Atoi(hourString)
+		if err != nil || parsed < 0 || parsed > 23 {
+			return "", fmt.Errorf("hour is not valid (between [0, 23], inclusive): %s", hourString)
+		}
+		hour = parsed
+	}
+
+	if cadence == "daily" {
+		return fmt.Sprintf("0~59 %d * * *", hour), nil
+	}
+
+	weekday, isWeekly := strings.CutPrefix(cadence, "weekly:")
+	if !isWeekly {
+		return "", fmt.Errorf("expected a cron expression, `daily` or `weekly:<weekday>`, got `%s`", schedule)
+	}
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if weekday == name || weekday == name[:3] {
+			return fmt.Sprintf("0~59 %d * * %d", hour, day), nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown weekday `%s`", weekday)
+}
+
+// Checks that a cron expression has five fields, each a comma-separated list of `*`, values, `a-b` or `a~b` ranges,
+// optionally followed by a `/step`, with every value in range
+func validateCron(expression string) error {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("cron expression `%s` must have %d fields (minute, hour, day of month, month, day of week), got %d", expression, len(cronFields), len(fields))
+	}
+
+	for idx, field := range fields {
+		spec := cronFields[idx]
+
+		value := func(text string) (int, bool) {
+			for nameIdx, name := range spec.names {
+				if strings.EqualFold(text, name) {
+					return nameIdx + spec.min, true
+				}
+			}
+
+			parsed, err := strconv.Atoi(text)
+			return parsed, err == nil && parsed >= spec.min && parsed <= spec.max
+		}
+
+		for _, item := range strings.Split(field, ",") {
+			rangeText, step, hasStep := strings.Cut(item, "/")
+			if hasStep {
+				parsed, err := strconv.Atoi(step)
+				if err != nil || parsed <= 0 {
+					return fmt.Errorf("invalid step `%s` in %s field `%s` of cron expression `%s`", step, spec.name, field, expression)
+				}
+			}
+
+			if rangeText == "*" {
+				continue
+			}
+
+			lowText, highText, isRange := strings.Cut(strings.Replace(rangeText, "~", "-", 1), "-")
+			low, valid := value(lowText)
+
+			if isRange {
+				high, validHigh := value(highText)
+				valid = valid && validHigh && low <= high
+			}
+
+			if !valid {
+				return fmt.Errorf("%s field `%s` of cron expression `%s` must be between [%d, %d], inclusive", spec.name, field, expression, spec.min, spec.max)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateSchedule(playlist, schedule string) error {
+	_, err := cronExpression(schedule, 0)
+	if err != nil {
+		return fmt.Errorf("invalid schedule for playlist %s: %v", playlist, err)
+	}
+
+	return nil
+}
+
+func scheduleId(username, playlist string) string {
+	return fmt.Sprintf("%s%s/%s", schedulePrefix, url.PathEscape(username), url.PathEscape(playlist))
+}
+
+// Registers a recurring scheduler callback for every source, playlist, generated, top, fresh releases and radio playlist with its own schedule.
+// These entries are excluded from the global daily sync
+func SchedulePlaylists(users []userConfig, defaultHour int) error {
+	for _, user := range users {
+		names := []string{}
+		schedules := []string{}
+
+		for _, source := range user.Sources {
+			names = append(names, source.PlaylistName)
+			schedules = append(schedules, source.Schedule)
+		}
+
+		for _, generated := range user.generatedPlaylists() {
+			names = append(names, generated.Name)
+			schedules = append(schedules, generated.Schedule)
+		}
+
+		for _, item := range user.Playlists {
+			names = append(names, item.Name)
+			schedules = append(schedules, item.Schedule)
+		}
+
+		for _, item := range user.TopPlaylists {
+			names = append(names, item.Name)
+			schedules = append(schedules, item.Schedule)
+		}
+
+		if user.FreshReleasesPlaylist != "" {
+			names = append(names, user.FreshReleasesPlaylist)
+			schedules = append(schedules, user.FreshReleasesSchedule)
+		}
+
+		for _, item := range user.RadioPlaylists {
+			names = append(names, item.Name)
+			schedules = append(schedules, item.Schedule)
+		}
+
+		for idx, name := range names {
+			cron, err := cronExpression(schedules[idx], defaultHour)
+			if err != nil {
+				return fmt.Errorf("invalid schedule for playlist %s: %v", name, err)
+			}
+
+			if cron == "" {
+				continue
+			}
+
+			payload, err := json.Marshal(scheduledPlaylist{Username: user.NDUsername, Playlist: name})
+			if err != nil {
+				return err
+			}
+
+			id := scheduleId(user.NDUsername, name)
+			_, err = host.SchedulerScheduleRecurring(cron, string(payload), id)
+			if err != nil {
+				return fmt.Errorf("failed to schedule sync of playlist %s for user %s. Is `%s` a valid cron expression? %v", name, user.NDUsername, cron, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Syncs every playlist without its own schedule
+func DailyFetch() error {
+	return fetchPlaylists(func(_, _, schedule string) bool {
+		return schedule == ""
+	})
+}
+
+// Syncs a single playlist from a per-playlist scheduler callback
+func ScheduledFetch(payload string) error {
+	var scheduled scheduledPlaylist
+	err := json.Unmarshal([]byte(payload), &scheduled)
+	if err != nil {
+		return fmt.Errorf("invalid scheduler payload `%s`: %v", payload, err)
+	}
+
+	return fetchPlaylists(func(username, playlist, _ string) bool {
+		return username == scheduled.Username && playlist == scheduled.Playlist
+	})
+}
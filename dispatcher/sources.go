@@ -0,0 +1,189 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+)
+
+// The Navidrome playlists imported for each source, per user
+const editionsPrefix = "editions/"
+
+// Whether a source patch is a glob pattern, such as `year-in-music-*`, rather than a single source patch
+func isPatchPattern(sourcePatch string) bool {
+	return strings.ContainsAny(sourcePatch, "*?[")
+}
+
+// Groups the playlists whose source patch matches pattern by source patch, newest first
+func sourcePlaylists(playlists []*listenbrainz.LbzPlaylist, pattern string) map[string][]*listenbrainz.LbzPlaylist {
+	groups := map[string][]*listenbrainz.LbzPlaylist{}
+
+	for _, playlist := range playlists {
+		patch := playlist.Extension.Extension.AdditionalMetadata.AlgorithmMetadata.SourcePatch
+		if patch == "" {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, patch); matched {
+			groups[patch] = append(groups[patch], playlist)
+		}
+	}
+
+	for _, editions := range groups {
+		slices.SortStableFunc(editions, func(a, b *listenbrainz.LbzPlaylist) int {
+			return b.Date.Compare(a.Date)
+		})
+	}
+
+	return groups
+}
+
+// The ISO week of a date, such as 2026-W41
+func isoWeek(date time.Time) string {
+	year, week := date.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// The Navidrome name of a source playlist: the template, with {title}, {patch}, {date} and {week} replaced by those of the playlist
+func sourceName(template, patch string, playlist *listenbrainz.LbzPlaylist) string {
+	return strings.NewReplacer(
+		"{title}", playlist.Title,
+		"{patch}", patch,
+		"{date}", playlist.Date.Format(time.DateOnly),
+		"{week}", isoWeek(playlist.Date),
+	).Replace(template)
+}
+
+// The Navidrome name of a previous edition of a source playlist: dated by week for weekly patches,
+// such as "Weekly Exploration – 2026-W41", and by day otherwise, such as "Daily Jams – 2026-10-12"
+func editionName(name, patch string, playlist *listenbrainz.LbzPlaylist) string {
+	if strings.HasPrefix(patch, "weekly-") {
+		return fmt.Sprintf("%s – %s", name, isoWeek(playlist.Date))
+	}
+
+	return fmt.Sprintf("%s – %s", name, playlist.Date.Format(time.DateOnly))
+}
+
+func editionsKey(username string, src source) string {
+	return fmt.Sprintf("%s%s/%s/%s", editionsPrefix, url.PathEscape(username), url.PathEscape(src.SourcePatch), url.PathEscape(src.PlaylistName))
+}
+
+// Deletes the playlists previously imported for a source which are no longer imported, such as editions out of its history
+// or playlists named after an older date. Only playlists imported for this source are ever deleted
+func (j *Job) pruneEditions(src source, names []string, existing func() *subsonic.JsonWrapper) *retry.Error {
+	key := editionsKey(j.Username, src)
+
+	previous := []string{}
+	if _, err := store.Get(key, &previous); err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read imported playlists of source `%s` for user %s: %v", src.SourcePatch, j.Username, err))
+	}
+
+	for _, name := range previous {
+		if slices.Contains(names, name) {
+			continue
+		}
+
+		if j.DryRun {
+			pdk.Log(pdk.LogInfo, fmt.Sprintf("Dry run: would delete playlist `%s` for user %s, as it is no longer imported", name, j.Username))
+			continue
+		}
+
+		if resp := existing(); resp != nil {
+			if local := subsonic.FindExistingPlaylist(resp, name); local != nil {
+				err := subsonic.DeletePlaylist(j.Username, local.Id)
+				if err != nil {
+					pdk.Log(pdk.LogError, fmt.Sprintf("Failed to delete playlist `%s` for user %s: %v", name, j.Username, err.Error))
+					return err
+				}
+			}
+		}
+
+		deletePlaylistState(j.Username, name)
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Deleted playlist `%s` for user %s, as it is no longer imported", name, j.Username))
+	}
+
+	if j.DryRun {
+		return nil
+	}
+
+	if err := store.Set(key, names); err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save imported playlists of source `%s` for user %s: %v", src.SourcePatch, j.Username, err))
+	}
+
+	return nil
+}
+
+// Queues an import of a ListenBrainz playlist as name, unless it was already imported.
+// A failed enqueue is retryable, while a failed serialization is not
+func (j *Job) queueImport(name string, playlist *listenbrainz.LbzPlaylist, existing func() *subsonic.JsonWrapper) *retry.Error {
+	lbzId := listenbrainz.GetIdentifier(playlist.Identifier)
+
+	if !j.DryRun && isUpToDate(j.Username, name, lbzId, playlist.Updated(), existing) {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Playlist `%s` for user %s is up to date with ListenBrainz playlist %s, skipping", name, j.Username, lbzId))
+		j.stats.setSkipped(name, "ListenBrainz playlist unchanged since last import")
+		return nil
+	}
+
+	// The import keeps the settings this job was built with by userJob, but none of its payload
+	newJob := *j
+	newJob.JobType = ImportPlaylist
+	newJob.Retries = 0
+	newJob.stats = runStats{}
+	newJob.Patch = nil
+	newJob.Import = &importJob{
+		Name:  name,
+		LbzId: lbzId,
+	}
+
+	payload, serializeErr := json.Marshal(newJob)
+	if serializeErr != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Error serializing import job: %v", serializeErr))
+		j.stats.setError(name, serializeErr)
+		return &retry.Error{Error: serializeErr, Retryable: false}
+	}
+
+	_, taskErr := host.TaskEnqueue(queueName, payload)
+	if taskErr != nil {
+		return retry.TempError(taskErr)
+	}
+
+	j.stats.setLbzId(name, lbzId)
+	return nil
+}
+
+// Logs every source patch currently available for the user, so admins know which sources they can subscribe to
+func (j *Job) dispatchDiscoverPatches() *retry.Error {
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Discovering source patches of ListenBrainz user %s", j.LbzUsername))
+
+	playlists, err := listenbrainz.GetCreatedForPlaylists(j.LbzUsername, j.LbzToken)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Failed to fetch playlists for user %s: %v", j.Username, err.Error))
+		return err
+	}
+
+	groups := sourcePlaylists(playlists, "*")
+	if len(groups) == 0 {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("No source patches available for ListenBrainz user %s", j.LbzUsername))
+		return nil
+	}
+
+	for _, patch := range slices.Sorted(maps.Keys(groups)) {
+		editions := groups[patch]
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Source patch `%s` available for ListenBrainz user %s: %d playlist(s), newest `%s` from %s",
+			patch, j.LbzUsername, len(editions), editions[0].Title, editions[0].Date.Format(time.DateOnly)))
+	}
+
+	return nil
+}
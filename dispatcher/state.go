@@ -0,0 +1,80 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/store"
+	"listenbrainz-daily-playlist/subsonic"
+	"net/url"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+)
+
+const statePrefix = "playlist/"
+
+// The ListenBrainz playlist (and version) that was last imported into a Navidrome playlist
+type playlistState struct {
+	LbzId   string    `json:"lbzId"`
+	Updated time.Time `json:"updated"`
+}
+
+func stateKey(username, playlist string) string {
+	return fmt.Sprintf("%s%s/%s", statePrefix, url.PathEscape(username), url.PathEscape(playlist))
+}
+
+func loadPlaylistState(username, playlist string) *playlistState {
+	var state playlistState
+
+	ok, err := store.Get(stateKey(username, playlist), &state)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to read state of playlist `%s` for user %s: %v", playlist, username, err))
+		return nil
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return &state
+}
+
+func savePlaylistState(username, playlist string, state playlistState) {
+	err := store.Set(stateKey(username, playlist), state)
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to save state of playlist `%s` for user %s: %v", playlist, username, err))
+	}
+}
+
+func deletePlaylistState(username, playlist string) {
+	err := store.Delete(stateKey(username, playlist))
+	if err != nil {
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Unable to delete state of playlist `%s` for user %s: %v", playlist, username, err))
+	}
+}
+
+// Whether the Navidrome playlist exists and was imported from this exact version of the ListenBrainz playlist.
+// playlists is fetched lazily, and only when the stored state matches
+func isUpToDate(username, playlist, lbzId string, updated time.Time, playlists func() *subsonic.JsonWrapper) bool {
+	state := loadPlaylistState(username, playlist)
+	if state == nil || state.LbzId != lbzId || !state.Updated.Equal(updated) {
+		return false
+	}
+
+	resp := playlists()
+	return resp != nil && subsonic.FindExistingPlaylist(resp, playlist) != nil
+}
+
+// Returns a function which fetches the playlists of a user at most once
+func lazyPlaylists(username string) func() *subsonic.JsonWrapper {
+	var resp *subsonic.JsonWrapper
+	fetched := false
+
+	return func() *subsonic.JsonWrapper {
+		if !fetched {
+			fetched = true
+			resp, _ = subsonic.Call("getPlaylists", username, &url.Values{"username": []string{username}})
+		}
+
+		return resp
+	}
+}
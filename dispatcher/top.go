@@ -0,0 +1,82 @@
+package dispatcher
+
+import (
+	"fmt"
+	"listenbrainz-daily-playlist/listenbrainz"
+	"listenbrainz-daily-playlist/retry"
+	"strings"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const defaultTopSize = 50
+
+// The ListenBrainz statistics ranges a top tracks playlist can be built from
+var statsRanges = []string{"week", "month", "quarter", "year", "all_time"}
+
+// Builds a playlist from the most listened recordings of the user, in order
+func (j *Job) dispatchTopTracks() *retry.Error {
+	if j.Top == nil {
+		return retry.FatalError("attempting to call top tracks job without top payload")
+	}
+
+	name := j.Top.Name
+	size := j.Top.Size
+	if size <= 0 {
+		size = defaultTopSize
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Building top tracks playlist `%s` (%s) for user %s", name, j.Top.Range, j.Username))
+
+	top, err := listenbrainz.GetTopRecordings(j.LbzUsername, j.LbzToken, j.Top.Range, size)
+	if err != nil {
+		pdk.Log(pdk.LogError, fmt.Sprintf("Unable to fetch top recordings for user %s: %v", j.Username, err.Error))
+		return err
+	}
+
+	// Changing the range or size should rebuild the playlist, even if the statistics did not change
+	stateId := fmt.Sprintf("top/%s/%d", j.Top.Range, size)
+	updated := time.Unix(top.Payload.LastUpdated, 0).UTC()
+
+	if !j.DryRun && isUpToDate(j.Username, name, stateId, updated, lazyPlaylists(j.Username)) {
+		pdk.Log(pdk.LogInfo, fmt.Sprintf("Playlist `%s` for user %s is up to date with ListenBrainz statistics, skipping", name, j.Username))
+		j.stats.setSkipped(name, "ListenBrainz statistics unchanged since last update")
+		return nil
+	}
+
+	recordings := top.Payload.Recordings
+	tracks := make([]types.SongRef, len(recordings))
+	creators := make([]string, len(recordings))
+
+	for idx, recording := range recordings {
+		tracks[idx].Name = recording.TrackName
+		tracks[idx].MBID = recording.RecordingMBID
+		tracks[idx].Album = recording.ReleaseName
+		tracks[idx].AlbumMBID = recording.ReleaseMBID
+		creators[idx] = recording.ArtistName
+
+		tracks[idx].Artists = make([]types.ArtistRef, len(recording.Artists))
+
+		for artistIdx, artist := range recording.Artists {
+			tracks[idx].Artists[artistIdx].Name = artist.ArtistCreditName
+			tracks[idx].Artists[artistIdx].MBID = artist.MBID
+		}
+	}
+
+	header := fmt.Sprintf("Top %d recordings (%s) from ListenBrainz statistics\nUpdated on: %s",
+		size, strings.ReplaceAll(j.Top.Range, "_", " "), updated.Format(time.RFC3339))
+
+	written, err := j.writeMatchedPlaylist(name, header, tracks, creators)
+	if err != nil || !written {
+		return err
+	}
+
+	if !j.DryRun {
+		savePlaylistState(j.Username, name, playlistState{LbzId: stateId, Updated: updated})
+	}
+
+	pdk.Log(pdk.LogInfo, fmt.Sprintf("Successfully updated top tracks playlist `%s` for user %s", name, j.Username))
+	return nil
+}
@@ -1,17 +1,33 @@
 package dispatcher
 
+import "strings"
+
 type JobType string
 
 const (
-	FetchPatches   JobType = "fetch-patches"
-	GenerateJams   JobType = "generate-jams"
-	ImportPlaylist JobType = "import-playlist"
+	FetchPatches    JobType = "fetch-patches"
+	GenerateJams    JobType = "generate-jams"
+	ImportPlaylist  JobType = "import-playlist"
+	SyncFeedback    JobType = "sync-feedback"
+	TopTracks       JobType = "top-tracks"
+	FreshReleases   JobType = "fresh-releases"
+	Radio           JobType = "lb-radio"
+	Mirror          JobType = "mirror-playlists"
+	DiscoverPatches JobType = "discover-patches"
+	Rematch         JobType = "rematch-missing"
 )
 
 type generationJob struct {
-	Name        string `json:"name"`
-	TrackAge    int    `json:"trackAge"`
-	ArtistLimit int    `json:"artistLimit"`
+	Name          string         `json:"name"`
+	TrackAge      int            `json:"trackAge"`
+	ArtistLimit   int            `json:"artistLimit"`
+	Size          int            `json:"size,omitempty"`
+	Duration      int            `json:"duration,omitempty"`
+	UnplayedRatio int            `json:"unplayedRatio,omitempty"`
+	Ratings       map[int32]bool `json:"ratings,omitempty"`
+	RepeatWindow  int            `json:"repeatWindow,omitempty"`
+	RepeatMode    string         `json:"repeatMode,omitempty"`
+	Source        string         `json:"source,omitempty"`
 }
 
 type importJob struct {
@@ -20,8 +36,42 @@ type importJob struct {
 }
 
 type source struct {
-	SourcePatch  string `json:"sourcePatch"`
+	// A source patch, or a glob pattern matching several source patches
+	SourcePatch string `json:"sourcePatch"`
+	// The playlist name, which may contain {title}, {patch}, {date} and {week}
 	PlaylistName string `json:"playlistName"`
+	Schedule     string `json:"schedule,omitempty"`
+	// The number of previous editions to import as separate dated playlists
+	History int `json:"history,omitempty"`
+}
+
+type topJob struct {
+	Name  string `json:"name"`
+	Range string `json:"range"`
+	Size  int    `json:"size"`
+}
+
+type freshJob struct {
+	Name   string `json:"name"`
+	Past   int    `json:"past"`
+	Future int    `json:"future"`
+}
+
+type radioJob struct {
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+	Mode   string `json:"mode"`
+}
+
+type mirrorJob struct {
+	NameTemplate string `json:"nameTemplate,omitempty"`
+	Delete       bool   `json:"delete,omitempty"`
+	// The names of the other playlists of the user, which are never mirrored over
+	Reserved []string `json:"reserved,omitempty"`
+}
+
+type feedbackSyncJob struct {
+	HateRating int `json:"hateRating"`
 }
 
 type patchJob struct {
@@ -35,26 +85,181 @@ type Job struct {
 	LbzToken    string         `json:"lbzToken"`
 	Ratings     map[int32]bool `json:"ratings"`
 
-	Generate *generationJob `json:"generate,omitempty"`
-	Import   *importJob     `json:"import,omitempty"`
-	Patch    *patchJob      `json:"patch,omitempty"`
+	FallbackCount   int  `json:"fallbackCount,omitempty"`
+	LedgerRetention int  `json:"ledgerRetention,omitempty"`
+	DryRun          bool `json:"dryRun,omitempty"`
+	ExcludeHated    bool `json:"excludeHated,omitempty"`
+	BoostLoved      bool `json:"boostLoved,omitempty"`
+	// The most recent ListenBrainz listens fetched to exclude recently played tracks. 0 disables this
+	RecentListenLimit int `json:"recentListenLimit,omitempty"`
+	// How long recording matches are cached, in hours. 0 disables the match cache
+	MatchCacheHours int `json:"matchCacheHours,omitempty"`
+	// The song ID recordings are always matched to, by recording MBID. An empty ID means the recording is never matched
+	Overrides  map[string]string  `json:"overrides,omitempty"`
+	Preference *versionPreference `json:"preference,omitempty"`
+	// Whether a match report is stored for every playlist
+	MatchReports bool `json:"matchReports,omitempty"`
+	// Whether missing tracks are remembered, to be added once a library scan finds them
+	RematchMissing bool `json:"rematchMissing,omitempty"`
+	// The number of times this job was rescheduled at a time hinted by ListenBrainz
+	Retries int `json:"retries,omitempty"`
+
+	Generated []generationJob  `json:"generated,omitempty"`
+	Import    *importJob       `json:"import,omitempty"`
+	Patch     *patchJob        `json:"patch,omitempty"`
+	Feedback  *feedbackSyncJob `json:"feedback,omitempty"`
+	Top       *topJob          `json:"top,omitempty"`
+	Fresh     *freshJob        `json:"fresh,omitempty"`
+	Radio     *radioJob        `json:"radio,omitempty"`
+	Mirror    *mirrorJob       `json:"mirror,omitempty"`
+
+	// What happened to each playlist during this run, for the ledger. Per run, so it is not serialized
+	stats runStats
 }
 
 type playlist struct {
-	Name    string `json:"name"`
-	LbzId   string `json:"lbzId"`
-	OneTime bool   `json:"oneTime"`
+	Name     string `json:"name"`
+	LbzId    string `json:"lbzId"`
+	OneTime  bool   `json:"oneTime"`
+	Schedule string `json:"schedule,omitempty"`
+}
+
+type generatedPlaylist struct {
+	Name          string   `json:"name"`
+	Size          int      `json:"size"`
+	Duration      int      `json:"duration"`
+	TrackAge      int      `json:"trackAge"`
+	ArtistLimit   int      `json:"artistLimit"`
+	UnplayedRatio int      `json:"unplayedRatio"`
+	Ratings       []string `json:"ratings,omitempty"`
+	Schedule      string   `json:"schedule,omitempty"`
+	RepeatWindow  int      `json:"repeatWindow"`
+	RepeatMode    string   `json:"repeatMode,omitempty"`
+	Source        string   `json:"source,omitempty"`
+}
+
+type topPlaylist struct {
+	Name     string `json:"name"`
+	Range    string `json:"range"`
+	Size     int    `json:"size"`
+	Schedule string `json:"schedule,omitempty"`
+}
+
+type radioPlaylist struct {
+	Name     string `json:"name"`
+	Prompt   string `json:"prompt"`
+	Mode     string `json:"mode"`
+	Schedule string `json:"schedule,omitempty"`
+}
+
+type matchOverride struct {
+	MBID   string `json:"mbid"`
+	SongID string `json:"songId,omitempty"`
+	Never  bool   `json:"never,omitempty"`
 }
 
 type userConfig struct {
-	GeneratePlaylist             bool       `json:"generatePlaylist"`
-	GeneratedPlaylist            string     `json:"generatedPlaylist"`
-	GeneratedPlaylistTrackAge    int        `json:"generatedPlaylistTrackAge"`
-	GeneratedPlaylistArtistLimit int        `json:"generatedPlaylistArtistLimit"`
-	NDUsername                   string     `json:"username"`
-	LbzUsername                  string     `json:"lbzUsername"`
-	LbzToken                     string     `json:"lbzToken"`
-	Ratings                      []string   `json:"ratings,omitempty"`
-	Sources                      []source   `json:"sources"`
-	Playlists                    []playlist `json:"playlists"`
+	GeneratePlaylist              bool       `json:"generatePlaylist"`
+	GeneratedPlaylist             string     `json:"generatedPlaylist"`
+	GeneratedPlaylistTrackAge     int        `json:"generatedPlaylistTrackAge"`
+	GeneratedPlaylistArtistLimit  int        `json:"generatedPlaylistArtistLimit"`
+	GeneratedPlaylistSchedule     string     `json:"generatedPlaylistSchedule,omitempty"`
+	GeneratedPlaylistSize         int        `json:"generatedPlaylistSize,omitempty"`
+	GeneratedPlaylistDuration     int        `json:"generatedPlaylistDuration,omitempty"`
+	GeneratedPlaylistRepeatWindow int        `json:"generatedPlaylistRepeatWindow,omitempty"`
+	GeneratedPlaylistRepeatMode   string     `json:"generatedPlaylistRepeatMode,omitempty"`
+	NDUsername                    string     `json:"username"`
+	LbzUsername                   string     `json:"lbzUsername"`
+	LbzToken                      string     `json:"lbzToken"`
+	Ratings                       []string   `json:"ratings,omitempty"`
+	Sources                       []source   `json:"sources"`
+	Playlists                     []playlist `json:"playlists"`
+	ExcludeHated                  bool       `json:"excludeHated,omitempty"`
+	BoostLoved                    bool       `json:"boostLoved,omitempty"`
+	SyncFeedback                  bool       `json:"syncFeedback,omitempty"`
+	FeedbackHateRating            int        `json:"feedbackHateRating,omitempty"`
+	RecentListenLimit             int        `json:"recentListenLimit,omitempty"`
+	FreshReleasesPlaylist         string     `json:"freshReleasesPlaylist,omitempty"`
+	FreshReleasesPast             int        `json:"freshReleasesPast,omitempty"`
+	FreshReleasesFuture           int        `json:"freshReleasesFuture,omitempty"`
+	FreshReleasesSchedule         string     `json:"freshReleasesSchedule,omitempty"`
+	MirrorPlaylists               bool       `json:"mirrorPlaylists,omitempty"`
+	MirrorNameTemplate            string     `json:"mirrorNameTemplate,omitempty"`
+	MirrorDelete                  bool       `json:"mirrorDelete,omitempty"`
+	DiscoverPatches               bool       `json:"discoverPatches,omitempty"`
+
+	GeneratedPlaylists []generatedPlaylist `json:"generatedPlaylists,omitempty"`
+	TopPlaylists       []topPlaylist       `json:"topPlaylists,omitempty"`
+	RadioPlaylists     []radioPlaylist     `json:"radioPlaylists,omitempty"`
+	MatchOverrides     []matchOverride     `json:"matchOverrides,omitempty"`
+	PreferVersions     []string            `json:"preferVersions,omitempty"`
+	PreferredLibrary   int                 `json:"preferredLibrary,omitempty"`
+}
+
+// The match overrides of this user, as the song ID by recording MBID
+func (u *userConfig) overrides() map[string]string {
+	if len(u.MatchOverrides) == 0 {
+		return nil
+	}
+
+	overrides := map[string]string{}
+	for _, override := range u.MatchOverrides {
+		overrides[override.MBID] = override.SongID
+	}
+
+	return overrides
+}
+
+// The names of every playlist configured for this user, other than mirrored ones.
+// Source names with placeholders are only known once fetched, so they are left out
+func (u *userConfig) playlistNames() []string {
+	names := []string{}
+
+	for _, source := range u.Sources {
+		if !strings.Contains(source.PlaylistName, "{") {
+			names = append(names, source.PlaylistName)
+		}
+	}
+
+	for _, item := range u.Playlists {
+		names = append(names, item.Name)
+	}
+
+	for _, item := range u.generatedPlaylists() {
+		names = append(names, item.Name)
+	}
+
+	for _, item := range u.TopPlaylists {
+		names = append(names, item.Name)
+	}
+
+	if u.FreshReleasesPlaylist != "" {
+		names = append(names, u.FreshReleasesPlaylist)
+	}
+
+	for _, item := range u.RadioPlaylists {
+		names = append(names, item.Name)
+	}
+
+	return names
+}
+
+// All generated playlists of this user, including the single generated playlist of the original configuration
+func (u *userConfig) generatedPlaylists() []generatedPlaylist {
+	playlists := []generatedPlaylist{}
+
+	if u.GeneratePlaylist && u.GeneratedPlaylist != "" {
+		playlists = append(playlists, generatedPlaylist{
+			Name:         u.GeneratedPlaylist,
+			Size:         u.GeneratedPlaylistSize,
+			Duration:     u.GeneratedPlaylistDuration,
+			TrackAge:     u.GeneratedPlaylistTrackAge,
+			ArtistLimit:  u.GeneratedPlaylistArtistLimit,
+			Schedule:     u.GeneratedPlaylistSchedule,
+			RepeatWindow: u.GeneratedPlaylistRepeatWindow,
+			RepeatMode:   u.GeneratedPlaylistRepeatMode,
+		})
+	}
+
+	return append(playlists, u.GeneratedPlaylists...)
 }
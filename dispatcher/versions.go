@@ -0,0 +1,190 @@
+package dispatcher
+
+import (
+	"cmp"
+	"fmt"
+	"listenbrainz-daily-playlist/retry"
+	"listenbrainz-daily-playlist/subsonic"
+	"slices"
+	"strings"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
+)
+
+const (
+	preferLibrary        = "library"
+	preferRelease        = "release"
+	preferNoCompilations = "noCompilations"
+	preferOriginal       = "original"
+	preferQuality        = "quality"
+)
+
+// The version preferences, from most to least important
+var versionPreferences = []string{preferLibrary, preferRelease, preferNoCompilations, preferOriginal, preferQuality}
+
+var losslessSuffixes = []string{"aiff", "alac", "ape", "dsf", "flac", "wav", "wv"}
+
+// Which copy of a recording to pick when the library has several
+type versionPreference struct {
+	// Prefer songs in this library. 0 disables this preference
+	Library int32 `json:"library,omitempty"`
+	// Prefer songs on the release ListenBrainz suggested
+	Release bool `json:"release,omitempty"`
+	// Prefer songs not on a compilation
+	NoCompilations bool `json:"noCompilations,omitempty"`
+	// Prefer songs on the earliest release
+	Original bool `json:"original,omitempty"`
+	// Prefer lossless songs, then songs with the highest bitrate
+	Quality bool `json:"quality,omitempty"`
+}
+
+func newVersionPreference(preferences []string, library int) *versionPreference {
+	if len(preferences) == 0 {
+		return nil
+	}
+
+	preference := &versionPreference{
+		Release:        slices.Contains(preferences, preferRelease),
+		NoCompilations: slices.Contains(preferences, preferNoCompilations),
+		Original:       slices.Contains(preferences, preferOriginal),
+		Quality:        slices.Contains(preferences, preferQuality),
+	}
+
+	if slices.Contains(preferences, preferLibrary) {
+		preference.Library = int32(library)
+	}
+
+	return preference
+}
+
+// Orders true before false
+func preferTrue(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isLossless(song *types.Track) bool {
+	return (song.BitDepth != nil && *song.BitDepth > 0) || slices.Contains(losslessSuffixes, strings.ToLower(song.Suffix))
+}
+
+// The release date of a song, as precise as it is known. Songs without a date sort last
+func releaseDate(song *types.Track) string {
+	switch {
+	case song.ReleaseDate != "":
+		return song.ReleaseDate
+	case song.ReleaseYear > 0:
+		return fmt.Sprintf("%04d", song.ReleaseYear)
+	case song.Date != "":
+		return song.Date
+	case song.Year > 0:
+		return fmt.Sprintf("%04d", song.Year)
+	default:
+		return "9999"
+	}
+}
+
+// Compares two copies of the recording ref, returning a negative number if a is preferred, and 0 if neither is
+func (p *versionPreference) compare(ref types.SongRef, a, b *types.Track) int {
+	if p.Library > 0 {
+		if c := preferTrue(a.LibraryID == p.Library, b.LibraryID == p.Library); c != 0 {
+			return c
+		}
+	}
+
+	if p.Release && ref.AlbumMBID != "" {
+		if c := preferTrue(a.MbzAlbumID == ref.AlbumMBID, b.MbzAlbumID == ref.AlbumMBID); c != 0 {
+			return c
+		}
+	}
+
+	if p.NoCompilations {
+		if c := preferTrue(!a.Compilation, !b.Compilation); c != 0 {
+			return c
+		}
+	}
+
+	if p.Original {
+		if c := cmp.Compare(releaseDate(a), releaseDate(b)); c != 0 {
+			return c
+		}
+	}
+
+	if p.Quality {
+		if c := preferTrue(isLossless(a), isLossless(b)); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(b.BitRate, a.BitRate)
+	}
+
+	return 0
+}
+
+// Whether two version preferences pick the same copies
+func (p *versionPreference) equal(other *versionPreference) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+
+	return *p == *other
+}
+
+// Replaces each match by the matcher with the preferred copy of the recording, if the library has several.
+// Other copies are found by searching the title of the match, and fetched by song ID.
+// Tracks passed by song ID, such as cached matches, were already resolved. Ties keep the song picked by the matcher
+func (j *Job) preferVersions(tracks []types.SongRef, matches []*types.Track) *retry.Error {
+	if j.Preference == nil {
+		return nil
+	}
+
+	refs := []types.SongRef{}
+	owners := []int{}
+
+	for idx, song := range matches {
+		if song == nil || tracks[idx].ID != "" || tracks[idx].MBID == "" {
+			continue
+		}
+
+		copies, err := subsonic.FindRecording(j.Username, song.Title, tracks[idx].MBID)
+		if err != nil {
+			return err
+		}
+
+		for _, other := range copies {
+			if other.Id != song.ID {
+				refs = append(refs, types.SongRef{ID: other.Id, Name: other.Title, MBID: tracks[idx].MBID})
+				owners = append(owners, idx)
+			}
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	copies, matchErr := host.MatcherMatchSongs(refs, host.MatchOptions{Username: j.Username})
+	if matchErr != nil {
+		return &retry.Error{Error: matchErr, Retryable: false}
+	}
+
+	for i, idx := range owners {
+		if i >= len(copies) || copies[i] == nil || copies[i].ID != refs[i].ID {
+			continue
+		}
+
+		if j.Preference.compare(tracks[idx], copies[i], matches[idx]) < 0 {
+			pdk.Log(pdk.LogDebug, fmt.Sprintf("Preferring `%s` from `%s` over `%s` for `%s`", copies[i].Title, copies[i].Album, matches[idx].Album, tracks[idx].Name))
+			matches[idx] = copies[i]
+		}
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"listenbrainz-daily-playlist/retry"
 	"listenbrainz-daily-playlist/sleep"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +16,16 @@ import (
 	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
 )
 
+const (
+	FeedbackLoved = 1
+	FeedbackHated = -1
+
+	feedbackPageSize  = 1000
+	listensPageSize   = 1000
+	playlistsPageSize = 100
+	statsPageSize     = 1000
+)
+
 const (
 	lbzEndpoint = "https://api.listenbrainz.org/1"
 	userAgent   = "NavidromePlaylistImporter/6.0.0 (https://github.com/kgarner7/navidrome-listenbrainz-daily-playlist)"
@@ -48,39 +59,107 @@ func processRatelimit(resp *host.HTTPResponse) {
 	}
 }
 
+// Looks up a response header, ignoring case
+func getHeader(resp *host.HTTPResponse, name string) (string, bool) {
+	for key, value := range resp.Headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// Returns how long ListenBrainz asked to wait before retrying, from the Retry-After header
+// (in seconds, or as an HTTP date). For rate limits, x-ratelimit-reset-in is used as a fallback
+func getRetryAfter(resp *host.HTTPResponse, rateLimited bool) time.Duration {
+	if value, ok := getHeader(resp, "retry-after"); ok {
+		value = strings.TrimSpace(value)
+
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+
+		if date, err := time.Parse(time.RFC1123, value); err == nil {
+			return max(time.Until(date), 0)
+		}
+
+		pdk.Log(pdk.LogWarn, fmt.Sprintf("Retry-After is not a valid number of seconds or date: %s", value))
+	}
+
+	if rateLimited {
+		if value, ok := getHeader(resp, "x-ratelimit-reset-in"); ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return 0
+}
+
+// Builds an error from a non-200 response. ListenBrainz normally responds with a JSON error,
+// but proxies and maintenance pages respond with HTML, in which case the page title (or start of the body) is used.
+// The second return value is false if the body could not be parsed
+func parseErrorBody(resp *host.HTTPResponse) (error, bool) {
+	var lbzErr lbzError
+	if err := json.Unmarshal(resp.Body, &lbzErr); err == nil {
+		return fmt.Errorf("ListenBrainz HTTP Error. Code: %d, Error: %s", lbzErr.Code, lbzErr.Error), true
+	}
+
+	body := strings.TrimSpace(string(resp.Body))
+
+	if start := strings.Index(body, "<title>"); start != -1 {
+		if end := strings.Index(body[start:], "</title>"); end != -1 {
+			body = body[start+len("<title>") : start+end]
+		}
+	}
+
+	if len(body) > 100 {
+		body = body[:100] + "..."
+	}
+
+	return fmt.Errorf("ListenBrainz HTTP Error. Code: %d, Error: unexpected response: %s", resp.StatusCode, body), false
+}
+
 func processHttpResponse(resp *host.HTTPResponse, err error) *retry.Error {
 	if err != nil {
 		message := err.Error()
-		retryable := strings.Contains(message, context.DeadlineExceeded.Error()) || strings.HasSuffix(message, ": connection reset by peer")
+		if strings.Contains(message, context.DeadlineExceeded.Error()) || strings.HasSuffix(message, ": connection reset by peer") {
+			return retry.TransientError(err, 0)
+		}
 
 		return &retry.Error{
 			Error:     err,
-			Retryable: retryable,
+			Retryable: false,
 		}
 	}
 
 	processRatelimit(resp)
 
-	if resp.StatusCode != 200 && resp.StatusCode != 429 {
-		var error lbzError
-		if err := json.Unmarshal(resp.Body, &error); err != nil {
-			return &retry.Error{
-				Error:     err,
-				Retryable: false,
-			}
-		}
-
-		return &retry.Error{
-			Error:     fmt.Errorf("ListenBrainz HTTP Error. Code: %d, Error: %s", error.Code, error.Error),
-			Retryable: false,
-		}
+	// Statistics which have not been calculated yet are a 204, which is handled by the caller
+	if resp.StatusCode == 200 || resp.StatusCode == 204 {
+		return nil
 	}
 
 	if resp.StatusCode == 429 {
-		return retry.TempError(errors.New("ListenBrainz rate limit hit"))
+		return retry.RateLimitError(errors.New("ListenBrainz rate limit hit"), getRetryAfter(resp, true))
 	}
 
-	return nil
+	httpErr, parsed := parseErrorBody(resp)
+
+	switch {
+	case resp.StatusCode >= 500:
+		return retry.TransientError(httpErr, getRetryAfter(resp, false))
+	case resp.StatusCode == 401 || resp.StatusCode == 403:
+		return retry.AuthError(httpErr)
+	case resp.StatusCode == 404:
+		return retry.NotFoundError(httpErr)
+	case !parsed:
+		return retry.MalformedError(httpErr)
+	default:
+		return &retry.Error{Error: httpErr, Retryable: false}
+	}
 }
 
 func makeLbzGet(endpoint, token string) (*host.HTTPResponse, *retry.Error) {
@@ -113,14 +192,29 @@ func makeLbzGet(endpoint, token string) (*host.HTTPResponse, *retry.Error) {
 }
 
 func GetPlaylist(id, lbzToken string) (*LbzPlaylist, *retry.Error) {
-	resp, err := makeLbzGet(fmt.Sprintf("%s/playlist/%s", lbzEndpoint, id), lbzToken)
+	return getPlaylist(fmt.Sprintf("%s/playlist/%s", lbzEndpoint, id), id, lbzToken)
+}
+
+// Fetches the most recent time a playlist was created or modified. Recording metadata is not looked up,
+// which makes this much cheaper than GetPlaylist
+func GetPlaylistUpdated(id, lbzToken string) (time.Time, *retry.Error) {
+	playlist, err := getPlaylist(fmt.Sprintf("%s/playlist/%s?fetch_metadata=false", lbzEndpoint, id), id, lbzToken)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return playlist.Updated(), nil
+}
+
+func getPlaylist(endpoint, id, lbzToken string) (*LbzPlaylist, *retry.Error) {
+	resp, err := makeLbzGet(endpoint, lbzToken)
 	if err != nil {
 		return nil, err
 	}
 
 	var result lbzPlaylistResponse
 	if err := json.Unmarshal(resp.Body, &result); err != nil {
-		return nil, &retry.Error{Error: err, Retryable: false}
+		return nil, retry.MalformedError(err)
 	}
 
 	if result.Playlist == nil {
@@ -130,20 +224,65 @@ func GetPlaylist(id, lbzToken string) (*LbzPlaylist, *retry.Error) {
 	return result.Playlist, nil
 }
 
-func GetCreatedForPlaylists(lbzUsername, lbzToken string) ([]*LbzPlaylist, *retry.Error) {
-	resp, err := makeLbzGet(fmt.Sprintf("%s/user/%s/playlists/createdfor", lbzEndpoint, lbzUsername), lbzToken)
+// Fetches a page of playlists from a playlist listing endpoint, such as /user/{name}/playlists
+func getPlaylistPage(endpoint, lbzToken string, count, offset int) (*page[*LbzPlaylist], *retry.Error) {
+	resp, err := makeLbzGet(fmt.Sprintf("%s?count=%d&offset=%d", endpoint, count, offset), lbzToken)
 	if err != nil {
 		return nil, err
 	}
 
 	var result lbzPlaylistResponse
 	if err := json.Unmarshal(resp.Body, &result); err != nil {
-		return nil, &retry.Error{Error: err, Retryable: false}
+		return nil, retry.MalformedError(err)
 	}
 
 	playlists := make([]*LbzPlaylist, len(result.Playlists))
-	for idx, playlist := range result.Playlists {
-		playlists[idx] = &playlist.Playlist
+	for idx := range result.Playlists {
+		playlists[idx] = &result.Playlists[idx].Playlist
+	}
+
+	return &page[*LbzPlaylist]{Items: playlists, Total: result.PlaylistCount}, nil
+}
+
+// Fetches every playlist created for a user, such as the playlists of troi-bot, one page at a time
+func GetCreatedForPlaylists(lbzUsername, lbzToken string) ([]*LbzPlaylist, *retry.Error) {
+	return getCreatedForPlaylists(lbzUsername, lbzToken, playlistsPageSize)
+}
+
+func getCreatedForPlaylists(lbzUsername, lbzToken string, pageSize int) ([]*LbzPlaylist, *retry.Error) {
+	endpoint := fmt.Sprintf("%s/user/%s/playlists/createdfor", lbzEndpoint, lbzUsername)
+
+	return paginate(pageSize, 0, func(count, offset int) (*page[*LbzPlaylist], *retry.Error) {
+		return getPlaylistPage(endpoint, lbzToken, count, offset)
+	})
+}
+
+// Fetches the playlists a user created or collaborates on, one page at a time. Playlists are listed without their tracks.
+// Private playlists are only listed if the token belongs to the user
+func GetUserPlaylists(lbzUsername, lbzToken string) ([]*LbzPlaylist, *retry.Error) {
+	return getUserPlaylists(lbzUsername, lbzToken, playlistsPageSize)
+}
+
+func getUserPlaylists(lbzUsername, lbzToken string, pageSize int) ([]*LbzPlaylist, *retry.Error) {
+	playlists := []*LbzPlaylist{}
+	seen := map[string]bool{}
+
+	for _, kind := range []string{"playlists", "playlists/collaborator"} {
+		endpoint := fmt.Sprintf("%s/user/%s/%s", lbzEndpoint, lbzUsername, kind)
+
+		listed, err := paginate(pageSize, 0, func(count, offset int) (*page[*LbzPlaylist], *retry.Error) {
+			return getPlaylistPage(endpoint, lbzToken, count, offset)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, playlist := range listed {
+			if !seen[playlist.Identifier] {
+				seen[playlist.Identifier] = true
+				playlists = append(playlists, playlist)
+			}
+		}
 	}
 
 	return playlists, nil
@@ -157,7 +296,7 @@ func GetRecommendations(lbzUsername, lbzToken string) (*LbzRecommendations, *ret
 	recommendations := LbzRecommendations{}
 	jsonErr := json.Unmarshal(resp.Body, &recommendations)
 	if jsonErr != nil {
-		return nil, &retry.Error{Error: jsonErr, Retryable: false}
+		return nil, retry.MalformedError(jsonErr)
 	}
 
 	if len(recommendations.Payload.MBIDs) == 0 {
@@ -167,7 +306,7 @@ func GetRecommendations(lbzUsername, lbzToken string) (*LbzRecommendations, *ret
 	return &recommendations, nil
 }
 
-func LookupRecordings(mbids []string, token string) (map[string]lbzMetadataLookup, *retry.Error) {
+func makeLbzPost(endpoint, token string, payload any) (*host.HTTPResponse, *retry.Error) {
 	headers := map[string]string{
 		"Accept":       "application/json",
 		"Content-Type": "application/json",
@@ -178,10 +317,7 @@ func LookupRecordings(mbids []string, token string) (map[string]lbzMetadataLooku
 		headers["Authorization"] = "Token " + token
 	}
 
-	payload := recLookup{RecordingMbids: mbids, Inc: "artist release"}
 	payloadBytes, _ := json.Marshal(payload)
-
-	endpoint := lbzEndpoint + "/metadata/recording"
 	start := time.Now()
 
 	resp, err := host.HTTPSend(host.HTTPRequest{
@@ -199,15 +335,228 @@ func LookupRecordings(mbids []string, token string) (map[string]lbzMetadataLooku
 		return nil, retryErr
 	}
 
+	return resp, nil
+}
+
+func LookupRecordings(mbids []string, token string) (map[string]lbzMetadataLookup, *retry.Error) {
+	payload := recLookup{RecordingMbids: mbids, Inc: "artist release"}
+
+	resp, retryErr := makeLbzPost(lbzEndpoint+"/metadata/recording", token, payload)
+	if retryErr != nil {
+		return nil, retryErr
+	}
+
 	var metadata map[string]lbzMetadataLookup
-	err = json.Unmarshal(resp.Body, &metadata)
+	err := json.Unmarshal(resp.Body, &metadata)
 	if err != nil {
-		return nil, &retry.Error{Error: err, Retryable: false}
+		return nil, retry.MalformedError(err)
 	}
 
 	return metadata, nil
 }
 
+// Sets the feedback of the token's user for a recording. A score of 0 removes the feedback
+func SubmitFeedback(mbid string, score int, lbzToken string) *retry.Error {
+	_, err := makeLbzPost(lbzEndpoint+"/feedback/recording-feedback", lbzToken, feedbackSubmission{RecordingMBID: mbid, Score: score})
+	return err
+}
+
+// Fetches the limit most listened recordings of a user over a statistics range (week, month, quarter, year or all_time), one page at a time
+func GetTopRecordings(lbzUsername, lbzToken, statsRange string, limit int) (*LbzTopRecordings, *retry.Error) {
+	return getTopRecordings(lbzUsername, lbzToken, statsRange, limit, statsPageSize)
+}
+
+func getTopRecordings(lbzUsername, lbzToken, statsRange string, limit, pageSize int) (*LbzTopRecordings, *retry.Error) {
+	var top *LbzTopRecordings
+
+	recordings, err := paginate(pageSize, limit, func(count, offset int) (*page[TopRecording], *retry.Error) {
+		resp, err := makeLbzGet(fmt.Sprintf("%s/stats/user/%s/recordings?count=%d&offset=%d&range=%s", lbzEndpoint, lbzUsername, count, offset, statsRange), lbzToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == 204 {
+			if top == nil {
+				return nil, retry.FatalError(fmt.Sprintf("ListenBrainz has not calculated %s statistics for user %s yet", statsRange, lbzUsername))
+			}
+
+			return &page[TopRecording]{Done: true}, nil
+		}
+
+		var current LbzTopRecordings
+		if err := json.Unmarshal(resp.Body, &current); err != nil {
+			return nil, retry.MalformedError(err)
+		}
+
+		if top == nil {
+			top = &current
+		}
+
+		return &page[TopRecording]{Items: current.Payload.Recordings, Total: current.Payload.TotalRecordingCount}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	top.Payload.Recordings = recordings
+	top.Payload.Count = len(recordings)
+	return top, nil
+}
+
+// Fetches the past and upcoming releases of artists the user listens to
+func GetFreshReleases(lbzUsername, lbzToken string) ([]FreshRelease, *retry.Error) {
+	resp, err := makeLbzGet(fmt.Sprintf("%s/user/%s/fresh_releases?sort=release_date&past=true&future=true", lbzEndpoint, lbzUsername), lbzToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var fresh lbzFreshReleasesResponse
+	if err := json.Unmarshal(resp.Body, &fresh); err != nil {
+		return nil, retry.MalformedError(err)
+	}
+
+	return fresh.Payload.Releases, nil
+}
+
+// Generates a playlist from an LB Radio prompt (e.g. `artist:(Radiohead)` or `tag:(shoegaze)`), in mode easy, medium or hard.
+// Each call generates a new playlist; nothing is saved on ListenBrainz
+func GetRadioPlaylist(prompt, mode, lbzToken string) (*LbzPlaylist, *retry.Error) {
+	resp, err := makeLbzGet(fmt.Sprintf("%s/explore/lb-radio?prompt=%s&mode=%s", lbzEndpoint, url.QueryEscape(prompt), mode), lbzToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var radio lbzRadioResponse
+	if err := json.Unmarshal(resp.Body, &radio); err != nil {
+		return nil, retry.MalformedError(err)
+	}
+
+	for _, message := range radio.Payload.Feedback {
+		pdk.Log(pdk.LogDebug, fmt.Sprintf("LB Radio feedback for prompt `%s`: %s", prompt, message))
+	}
+
+	if len(radio.Payload.Jspf.Playlist.Tracks) == 0 {
+		return nil, retry.FatalError(fmt.Sprintf("LB Radio generated no tracks for prompt `%s`: %s", prompt, strings.Join(radio.Payload.Feedback, " ")))
+	}
+
+	return &radio.Payload.Jspf.Playlist, nil
+}
+
+// Fetches all recording feedback of a user, one page at a time.
+// Returns a mapping of recording MBID to score (FeedbackLoved or FeedbackHated).
+// Feedback for listens without a recording MBID is skipped
+func GetFeedback(lbzUsername, lbzToken string) (map[string]int, *retry.Error) {
+	items, err := paginate(feedbackPageSize, 0, func(count, offset int) (*page[lbzFeedback], *retry.Error) {
+		resp, err := makeLbzGet(fmt.Sprintf("%s/feedback/user/%s/get-feedback?count=%d&offset=%d", lbzEndpoint, lbzUsername, count, offset), lbzToken)
+		if err != nil {
+			return nil, err
+		}
+
+		var current lbzFeedbackResponse
+		if err := json.Unmarshal(resp.Body, &current); err != nil {
+			return nil, retry.MalformedError(err)
+		}
+
+		return &page[lbzFeedback]{Items: current.Feedback, Total: current.TotalCount}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	feedback := map[string]int{}
+	for _, item := range items {
+		if item.RecordingMBID != "" {
+			feedback[item.RecordingMBID] = item.Score
+		}
+	}
+
+	return feedback, nil
+}
+
+// Fetches the listens of a user since a given time, newest first, fetching at most limit listens.
+// Returns a mapping of recording MBID to the time it was last listened to. Listens without a recording MBID are skipped
+func GetRecentListens(lbzUsername, lbzToken string, since time.Time, limit int) (map[string]time.Time, *retry.Error) {
+	return getRecentListens(lbzUsername, lbzToken, since, limit, listensPageSize)
+}
+
+func getRecentListens(lbzUsername, lbzToken string, since time.Time, limit, pageSize int) (map[string]time.Time, *retry.Error) {
+	var maxTs int64 = 0
+
+	// Listens are paged by timestamp rather than offset. max_ts is exclusive, so each page starts at the oldest listen
+	// of the previous one, to include listens at that second which did not fit. Listens fetched twice are dropped below
+	listens, err := paginate(pageSize, limit, func(count, _ int) (*page[lbzListen], *retry.Error) {
+		endpoint := fmt.Sprintf("%s/user/%s/listens?count=%d", lbzEndpoint, lbzUsername, count)
+		if maxTs > 0 {
+			endpoint += fmt.Sprintf("&max_ts=%d", maxTs)
+		}
+
+		resp, err := makeLbzGet(endpoint, lbzToken)
+		if err != nil {
+			return nil, err
+		}
+
+		var current lbzListensResponse
+		if err := json.Unmarshal(resp.Body, &current); err != nil {
+			return nil, retry.MalformedError(err)
+		}
+
+		for idx, listen := range current.Payload.Listens {
+			if time.Unix(listen.ListenedAt, 0).Before(since) {
+				return &page[lbzListen]{Items: current.Payload.Listens[:idx], Done: true}, nil
+			}
+		}
+
+		if count := len(current.Payload.Listens); count > 0 {
+			last := current.Payload.Listens[count-1].ListenedAt
+
+			// A full page of listens at a single second would be fetched again forever, so move on to earlier listens
+			if maxTs == last+1 && current.Payload.Listens[0].ListenedAt == last {
+				maxTs = last
+			} else {
+				maxTs = last + 1
+			}
+		}
+
+		return &page[lbzListen]{Items: current.Payload.Listens}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listened := map[string]time.Time{}
+	seen := map[string]bool{}
+
+	for _, listen := range listens {
+		key := fmt.Sprintf("%d/%s", listen.ListenedAt, listen.RecordingMSID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		mbid := listen.recordingMBID()
+		if mbid == "" {
+			continue
+		}
+
+		listenedAt := time.Unix(listen.ListenedAt, 0)
+		if previous, ok := listened[mbid]; !ok || listenedAt.After(previous) {
+			listened[mbid] = listenedAt
+		}
+	}
+
+	return listened, nil
+}
+
+// Returns the most recent time this playlist was created or modified
+func (p *LbzPlaylist) Updated() time.Time {
+	modified := p.Extension.Extension.LastModifiedAt
+	if modified.After(p.Date) {
+		return modified
+	}
+
+	return p.Date
+}
+
 func GetIdentifier(url string) string {
 	split := strings.Split(url, "/")
 	return split[len(split)-1]
@@ -30,6 +30,7 @@ var _ = Describe("ListenBrainz endpoints", func() {
 	)
 
 	var sleepDuration *time.Duration
+	listenedAt := time.Date(2026, 01, 01, 20, 12, 4, 0, time.UTC)
 
 	mockSleep := func(d time.Duration) {
 		sleepDuration = &d
@@ -169,6 +170,25 @@ var _ = Describe("ListenBrainz endpoints", func() {
 		)
 	})
 
+	Describe("GetPlaylistUpdated", func() {
+		It("should read the date without looking up recording metadata", func() {
+			request := testdata.MakeLbzRequest(lbzEndpoint+"/playlist/"+EMPTY_UUID+"?fetch_metadata=false", "", nil)
+			setupResponse(request, 200, "getPlaylist.success", nil, false)
+
+			updated, err := GetPlaylistUpdated(EMPTY_UUID, "")
+			Expect(err).To(BeNil())
+			Expect(updated.IsZero()).To(BeTrue())
+		})
+
+		It("should return errors", func() {
+			request := testdata.MakeLbzRequest(lbzEndpoint+"/playlist/1234?fetch_metadata=false", "", nil)
+			setupResponse(request, 400, "getPlaylist.error", nil, false)
+
+			_, err := GetPlaylistUpdated("1234", "")
+			Expect(err).To(Equal(retry.FatalError("ListenBrainz HTTP Error. Code: 400, Error: Provided playlist ID is invalid.")))
+		})
+	})
+
 	Describe("GetCreatedForPlaylists", func() {
 		DescribeTable("requests",
 			func(
@@ -177,7 +197,7 @@ var _ = Describe("ListenBrainz endpoints", func() {
 				expectedPlaylists []*LbzPlaylist, expectedErr *retry.Error,
 			) {
 
-				url := fmt.Sprintf("%s/user/%s/playlists/createdfor", lbzEndpoint, user)
+				url := fmt.Sprintf("%s/user/%s/playlists/createdfor?count=100&offset=0", lbzEndpoint, user)
 				request := testdata.MakeLbzRequest(url, token, nil)
 				setupResponse(request, code, dataPath, err, rateLimited)
 				actualPlaylists, actualErr := GetCreatedForPlaylists(user, token)
@@ -222,6 +242,7 @@ var _ = Describe("ListenBrainz endpoints", func() {
 										SourcePatch: "weekly-exploration",
 									},
 								},
+								LastModifiedAt: time.Date(2026, 02, 23, 11, 49, 14, 191092000, time.UTC),
 							},
 						},
 						Identifier: "https://listenbrainz.org/playlist/00000000-0000-0000-0000-000000000000",
@@ -231,6 +252,81 @@ var _ = Describe("ListenBrainz endpoints", func() {
 				}, nil,
 			),
 		)
+
+		It("fetches every page", func() {
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists/createdfor?count=2&offset=0", EMPTY_UUID, nil), 200, "userPlaylists.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists/createdfor?count=2&offset=2", EMPTY_UUID, nil), 200, "userPlaylists.page2", nil, false)
+
+			playlists, err := getCreatedForPlaylists("test", EMPTY_UUID, 2)
+			Expect(err).To(BeNil())
+			Expect(playlists).To(HaveLen(3))
+			Expect(playlists[2].Title).To(Equal("Late Night"))
+			host.HTTPMock.AssertNumberOfCalls(GinkgoT(), "Send", 2)
+		})
+	})
+
+	Describe("paginate", func() {
+		type request struct{ count, offset int }
+
+		// Serves pages of consecutive numbers from 0 to available (excluded), recording what was requested
+		serve := func(available, total int, requests *[]request) func(count, offset int) (*page[int], *retry.Error) {
+			return func(count, offset int) (*page[int], *retry.Error) {
+				*requests = append(*requests, request{count, offset})
+
+				items := []int{}
+				for idx := offset; idx < min(offset+count, available); idx++ {
+					items = append(items, idx)
+				}
+
+				return &page[int]{Items: items, Total: total}, nil
+			}
+		}
+
+		It("stops once the reported total is reached", func() {
+			requests := []request{}
+			items, err := paginate(2, 0, serve(10, 3, &requests))
+			Expect(err).To(BeNil())
+			Expect(items).To(Equal([]int{0, 1, 2, 3}))
+			Expect(requests).To(Equal([]request{{2, 0}, {2, 2}}))
+		})
+
+		It("stops at a short page without a total", func() {
+			requests := []request{}
+			items, err := paginate(2, 0, serve(5, 0, &requests))
+			Expect(err).To(BeNil())
+			Expect(items).To(Equal([]int{0, 1, 2, 3, 4}))
+			Expect(requests).To(Equal([]request{{2, 0}, {2, 2}, {2, 4}}))
+		})
+
+		It("only requests up to the limit", func() {
+			requests := []request{}
+			items, err := paginate(2, 3, serve(10, 0, &requests))
+			Expect(err).To(BeNil())
+			Expect(items).To(Equal([]int{0, 1, 2}))
+			Expect(requests).To(Equal([]request{{2, 0}, {1, 2}}))
+		})
+
+		It("stops when the caller is done", func() {
+			calls := 0
+			items, err := paginate(2, 0, func(count, offset int) (*page[int], *retry.Error) {
+				calls++
+				return &page[int]{Items: []int{offset}, Done: true}, nil
+			})
+			Expect(err).To(BeNil())
+			Expect(items).To(Equal([]int{0}))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("returns the error of a failed page", func() {
+			items, err := paginate(2, 0, func(count, offset int) (*page[int], *retry.Error) {
+				if offset > 0 {
+					return nil, retry.TempError(CONNECTION_RESET)
+				}
+				return &page[int]{Items: []int{0, 1}}, nil
+			})
+			Expect(items).To(BeNil())
+			Expect(err).To(Equal(retry.TempError(CONNECTION_RESET)))
+		})
 	})
 
 	Describe("GetRecommendations", func() {
@@ -275,7 +371,7 @@ var _ = Describe("ListenBrainz endpoints", func() {
 				"Handle valid response", "test", EMPTY_UUID,
 				200, "getRecommendations.success", nil, true,
 				&LbzRecommendations{
-					Payload: RecommendationPayload{Count: 1, LastUpdated: 1771845555, MBIDs: []RecordingMBID{{RecordingMBID: "00000000-0000-0000-0000-000000000000"}}},
+					Payload: RecommendationPayload{Count: 1, LastUpdated: 1771845555, MBIDs: []RecordingMBID{{RecordingMBID: "00000000-0000-0000-0000-000000000000", Score: 1.1590198278427124, LatestListenedAt: &listenedAt}}},
 				}, nil,
 			),
 			Entry(
@@ -295,8 +391,288 @@ var _ = Describe("ListenBrainz endpoints", func() {
 			actualRecommendations, actualErr := GetRecommendations("a", "")
 			Expect(actualRecommendations).To(BeNil())
 			Expect(actualErr).ToNot(BeNil())
-			Expect(actualErr.Error).To(MatchError("invalid character '<' looking for beginning of value"))
+			Expect(actualErr.Error).To(MatchError("ListenBrainz HTTP Error. Code: 415, Error: unexpected response: 415 Unsupported Media Type"))
 			Expect(actualErr.Retryable).To(BeFalse())
+			Expect(actualErr.Kind).To(Equal(retry.Malformed))
+		})
+	})
+
+	Describe("GetFeedback", func() {
+		feedbackUrl := func(offset int) string {
+			return fmt.Sprintf("%s/feedback/user/test/get-feedback?count=%d&offset=%d", lbzEndpoint, feedbackPageSize, offset)
+		}
+
+		It("fetches every page", func() {
+			setupResponse(testdata.MakeLbzRequest(feedbackUrl(0), EMPTY_UUID, nil), 200, "feedback.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(feedbackUrl(2), EMPTY_UUID, nil), 200, "feedback.page2", nil, false)
+
+			feedback, err := GetFeedback("test", EMPTY_UUID)
+			Expect(err).To(BeNil())
+			Expect(feedback).To(Equal(map[string]int{
+				"9980309d-3480-4e7e-89ce-fce971a452be": FeedbackLoved,
+				"7e4bb014-51d5-4943-adb1-683e066a5220": FeedbackHated,
+			}))
+			host.HTTPMock.AssertNumberOfCalls(GinkgoT(), "Send", 2)
+		})
+
+		It("returns the error of a failed page", func() {
+			setupResponse(testdata.MakeLbzRequest(feedbackUrl(0), EMPTY_UUID, nil), 200, "feedback.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(feedbackUrl(2), EMPTY_UUID, nil), 0, "", CONNECTION_RESET, false)
+
+			feedback, err := GetFeedback("test", EMPTY_UUID)
+			Expect(feedback).To(BeNil())
+			Expect(err).To(Equal(retry.TransientError(CONNECTION_RESET, 0)))
+		})
+	})
+
+	Describe("GetTopRecordings", func() {
+		const url = lbzEndpoint + "/stats/user/test/recordings?count=50&offset=0&range=month"
+
+		It("returns the top recordings", func() {
+			setupResponse(testdata.MakeLbzRequest(url, "", nil), 200, "topRecordings.success", nil, false)
+
+			top, err := GetTopRecordings("test", "", "month", 50)
+			Expect(err).To(BeNil())
+			Expect(top.Payload.LastUpdated).To(Equal(int64(1771845555)))
+			Expect(top.Payload.Recordings).To(Equal([]TopRecording{
+				{
+					ArtistName:    "Mili",
+					Artists:       []Artist{{ArtistCreditName: "Mili", MBID: "d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"}},
+					ListenCount:   42,
+					RecordingMBID: "9980309d-3480-4e7e-89ce-fce971a452be",
+					ReleaseMBID:   "2ff07e32-4f73-4b43-bb54-6f1b5d5e1f0a",
+					ReleaseName:   "Miracle Milk",
+					TrackName:     "world.execute(me);",
+				},
+			}))
+		})
+
+		It("errors if statistics are not calculated yet", func() {
+			setupResponse(testdata.MakeLbzRequest(url, "", nil), 204, "topRecordings.notCalculated", nil, false)
+
+			top, err := GetTopRecordings("test", "", "month", 50)
+			Expect(top).To(BeNil())
+			Expect(err).To(Equal(retry.FatalError("ListenBrainz has not calculated month statistics for user test yet")))
+		})
+	})
+
+	Describe("GetUserPlaylists", func() {
+		It("fetches owned and collaborative playlists one page at a time", func() {
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=2&offset=0", EMPTY_UUID, nil), 200, "userPlaylists.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=2&offset=2", EMPTY_UUID, nil), 200, "userPlaylists.page2", nil, false)
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists/collaborator?count=2&offset=0", EMPTY_UUID, nil), 200, "collaboratorPlaylists.success", nil, false)
+
+			playlists, err := getUserPlaylists("test", EMPTY_UUID, 2)
+			Expect(err).To(BeNil())
+
+			titles := []string{}
+			for _, playlist := range playlists {
+				titles = append(titles, playlist.Title)
+			}
+			Expect(titles).To(Equal([]string{"Road Trip", "Rainy Days", "Late Night", "Shared Mix"}))
+			Expect(playlists[3].Creator).To(Equal("friend"))
+			Expect(playlists[0].Updated()).To(BeTemporally("==", time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC)))
+		})
+
+		It("fails if a page cannot be fetched", func() {
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=2&offset=0", EMPTY_UUID, nil), 200, "userPlaylists.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(lbzEndpoint+"/user/test/playlists?count=2&offset=2", EMPTY_UUID, nil), 0, "", CONNECTION_RESET, false)
+
+			playlists, err := getUserPlaylists("test", EMPTY_UUID, 2)
+			Expect(playlists).To(BeNil())
+			Expect(err).To(Equal(retry.TransientError(CONNECTION_RESET, 0)))
+		})
+	})
+
+	Describe("GetRadioPlaylist", func() {
+		url := lbzEndpoint + "/explore/lb-radio?prompt=artist%3A%28Mili%29&mode=easy"
+
+		It("returns the generated playlist", func() {
+			setupResponse(testdata.MakeLbzRequest(url, EMPTY_UUID, nil), 200, "radio.success", nil, false)
+
+			playlist, err := GetRadioPlaylist("artist:(Mili)", "easy", EMPTY_UUID)
+			Expect(err).To(BeNil())
+			Expect(playlist.Title).To(Equal("Radio for artist:(Mili), mode easy"))
+			Expect(playlist.Tracks).To(HaveLen(2))
+			Expect(GetIdentifier(playlist.Tracks[0].Identifier[0])).To(Equal("9980309d-3480-4e7e-89ce-fce971a452be"))
+		})
+
+		It("surfaces an invalid prompt", func() {
+			setupResponse(testdata.MakeLbzRequest(url, EMPTY_UUID, nil), 400, "radio.badPrompt", nil, false)
+
+			playlist, err := GetRadioPlaylist("artist:(Mili)", "easy", EMPTY_UUID)
+			Expect(playlist).To(BeNil())
+			Expect(err).To(Equal(retry.FatalError("ListenBrainz HTTP Error. Code: 400, Error: The prompt could not be parsed: unknown entity `foo`")))
+		})
+	})
+
+	Describe("GetFreshReleases", func() {
+		It("returns the fresh releases", func() {
+			url := lbzEndpoint + "/user/test/fresh_releases?sort=release_date&past=true&future=true"
+			setupResponse(testdata.MakeLbzRequest(url, "", nil), 200, "freshReleases.success", nil, false)
+
+			releases, err := GetFreshReleases("test", "")
+			Expect(err).To(BeNil())
+			Expect(releases).To(HaveLen(3))
+			Expect(releases[0]).To(Equal(FreshRelease{
+				ArtistCreditName: "Mili",
+				ArtistMBIDs:      []string{"d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"},
+				ReleaseDate:      "2026-02-10",
+				ReleaseGroupMBID: "8c1b8f3e-6f4e-4d1e-9c55-2d9d7a3f1b20",
+				ReleaseMBID:      "2ff07e32-4f73-4b43-bb54-6f1b5d5e1f0a",
+				ReleaseName:      "Miracle Milk",
+			}))
+		})
+	})
+
+	Describe("GetRecentListens", func() {
+		since := time.Unix(1771800000, 0)
+
+		listensUrl := func(count int, maxTs int64) string {
+			url := fmt.Sprintf("%s/user/test/listens?count=%d", lbzEndpoint, count)
+			if maxTs > 0 {
+				url += fmt.Sprintf("&max_ts=%d", maxTs)
+			}
+			return url
+		}
+
+		It("fetches pages until a listen is older than the window", func() {
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 0), EMPTY_UUID, nil), 200, "listens.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 1771844901), EMPTY_UUID, nil), 200, "listens.page2", nil, false)
+
+			listened, err := getRecentListens("test", EMPTY_UUID, since, 10, 2)
+			Expect(err).To(BeNil())
+			Expect(listened).To(Equal(map[string]time.Time{
+				"9980309d-3480-4e7e-89ce-fce971a452be": time.Unix(1771845555, 0),
+				"7e4bb014-51d5-4943-adb1-683e066a5220": time.Unix(1771844900, 0),
+			}))
+			host.HTTPMock.AssertNumberOfCalls(GinkgoT(), "Send", 2)
+		})
+
+		It("fetches listens at the last second of a page that did not fit on it", func() {
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 0), EMPTY_UUID, nil), 200, "listens.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 1771844901), EMPTY_UUID, nil), 200, "listens.boundary", nil, false)
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 1771844900), EMPTY_UUID, nil), 200, "listens.page2", nil, false)
+
+			listened, err := getRecentListens("test", EMPTY_UUID, since, 10, 2)
+			Expect(err).To(BeNil())
+			Expect(listened).To(Equal(map[string]time.Time{
+				"9980309d-3480-4e7e-89ce-fce971a452be": time.Unix(1771845555, 0),
+				"7e4bb014-51d5-4943-adb1-683e066a5220": time.Unix(1771844900, 0),
+				"3f1e0c55-4f0c-4f6a-9b3b-2b8e3f0b6c11": time.Unix(1771844900, 0),
+			}))
+			host.HTTPMock.AssertNumberOfCalls(GinkgoT(), "Send", 3)
+		})
+
+		It("stops at the listen limit", func() {
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 0), EMPTY_UUID, nil), 200, "listens.page1", nil, false)
+
+			listened, err := getRecentListens("test", EMPTY_UUID, since, 2, 1000)
+			Expect(err).To(BeNil())
+			Expect(listened).To(HaveLen(2))
+			host.HTTPMock.AssertNumberOfCalls(GinkgoT(), "Send", 1)
+		})
+
+		It("returns the error of a failed page", func() {
+			setupResponse(testdata.MakeLbzRequest(listensUrl(2, 0), EMPTY_UUID, nil), 200, "listens.page1", nil, false)
+			setupResponse(testdata.MakeLbzRequest(listensUrl(1, 1771844901), EMPTY_UUID, nil), 0, "", CONNECTION_RESET, false)
+
+			listened, err := getRecentListens("test", EMPTY_UUID, since, 3, 2)
+			Expect(listened).To(BeNil())
+			Expect(err).To(Equal(retry.TransientError(CONNECTION_RESET, 0)))
+		})
+	})
+
+	Describe("SubmitFeedback", func() {
+		const url = lbzEndpoint + "/feedback/recording-feedback"
+
+		It("posts the score of a recording", func() {
+			request := testdata.MakeLbzRequest(url, EMPTY_UUID, []byte(`{"recording_mbid":"9980309d-3480-4e7e-89ce-fce971a452be","score":1}`))
+			setupResponse(request, 200, "submitFeedback.success", nil, false)
+
+			err := SubmitFeedback("9980309d-3480-4e7e-89ce-fce971a452be", FeedbackLoved, EMPTY_UUID)
+			Expect(err).To(BeNil())
+			host.HTTPMock.AssertExpectations(GinkgoT())
+		})
+
+		It("rejects a missing token", func() {
+			request := testdata.MakeLbzRequest(url, "", []byte(`{"recording_mbid":"9980309d-3480-4e7e-89ce-fce971a452be","score":-1}`))
+			setupResponse(request, 401, "submitFeedback.unauthorized", nil, false)
+
+			err := SubmitFeedback("9980309d-3480-4e7e-89ce-fce971a452be", FeedbackHated, "")
+			Expect(err).To(Equal(retry.AuthError(errors.New("ListenBrainz HTTP Error. Code: 401, Error: You need to provide an Authorization header."))))
+		})
+	})
+
+	Describe("error handling", func() {
+		DescribeTable("classifies responses",
+			func(resp *host.HTTPResponse, kind retry.Kind, retryable bool, retryAfter time.Duration, message string) {
+				request := testdata.MakeLbzRequest(lbzEndpoint+"/playlist/1234", "", nil)
+				host.HTTPMock.On("Send", request).Return(resp, nil)
+
+				_, actualErr := GetPlaylist("1234", "")
+				Expect(actualErr).ToNot(BeNil())
+				Expect(actualErr.Error).To(MatchError(message))
+				Expect(actualErr.Kind).To(Equal(kind))
+				Expect(actualErr.Retryable).To(Equal(retryable))
+				Expect(actualErr.RetryAfter).To(Equal(retryAfter))
+			},
+			Entry(
+				"Retries a maintenance page with Retry-After",
+				&host.HTTPResponse{StatusCode: 503, Headers: map[string]string{"Retry-After": "120"}, Body: []byte("<html><title>503 Service Unavailable</title></html>")},
+				retry.Transient, true, 2*time.Minute,
+				"ListenBrainz HTTP Error. Code: 503, Error: unexpected response: 503 Service Unavailable",
+			),
+			Entry(
+				"Retries a bad gateway without a hint",
+				&host.HTTPResponse{StatusCode: 502, Body: []byte("Bad Gateway")},
+				retry.Transient, true, time.Duration(0),
+				"ListenBrainz HTTP Error. Code: 502, Error: unexpected response: Bad Gateway",
+			),
+			Entry(
+				"Retries a JSON server error",
+				&host.HTTPResponse{StatusCode: 500, Body: []byte(`{"code":500,"error":"Internal server error"}`)},
+				retry.Transient, true, time.Duration(0),
+				"ListenBrainz HTTP Error. Code: 500, Error: Internal server error",
+			),
+			Entry(
+				"Retries a rate limit at the reset time",
+				&host.HTTPResponse{StatusCode: 429, Headers: map[string]string{"x-ratelimit-remaining": "0", "x-ratelimit-reset-in": "7"}, Body: []byte("{}")},
+				retry.RateLimited, true, 7*time.Second,
+				"ListenBrainz rate limit hit",
+			),
+			Entry(
+				"Prefers Retry-After for a rate limit",
+				&host.HTTPResponse{StatusCode: 429, Headers: map[string]string{"retry-after": "9", "x-ratelimit-reset-in": "7"}, Body: []byte("{}")},
+				retry.RateLimited, true, 9*time.Second,
+				"ListenBrainz rate limit hit",
+			),
+			Entry(
+				"Does not retry an invalid token",
+				&host.HTTPResponse{StatusCode: 401, Body: []byte(`{"code":401,"error":"Invalid authorization token."}`)},
+				retry.Unauthorized, false, time.Duration(0),
+				"ListenBrainz HTTP Error. Code: 401, Error: Invalid authorization token.",
+			),
+			Entry(
+				"Does not retry a missing playlist",
+				&host.HTTPResponse{StatusCode: 404, Body: []byte(`{"code":404,"error":"Cannot find playlist: 1234"}`)},
+				retry.NotFound, false, time.Duration(0),
+				"ListenBrainz HTTP Error. Code: 404, Error: Cannot find playlist: 1234",
+			),
+			Entry(
+				"Does not retry a malformed success",
+				&host.HTTPResponse{StatusCode: 200, Body: []byte("<html></html>")},
+				retry.Malformed, false, time.Duration(0),
+				"invalid character '<' looking for beginning of value",
+			),
+		)
+
+		It("parses an HTTP date in Retry-After", func() {
+			date := time.Now().Add(time.Hour).UTC().Format(time.RFC1123)
+			resp := &host.HTTPResponse{StatusCode: 503, Headers: map[string]string{"Retry-After": date}, Body: []byte("")}
+
+			retryAfter := getRetryAfter(resp, false)
+			Expect(retryAfter).To(BeNumerically("~", time.Hour, time.Minute))
 		})
 	})
 
@@ -0,0 +1,47 @@
+package listenbrainz
+
+import "listenbrainz-daily-playlist/retry"
+
+// A single page of a paginated ListenBrainz endpoint
+type page[T any] struct {
+	Items []T
+	// The number of items across all pages, or 0 if the endpoint does not report it.
+	// Without a total, a page shorter than requested is the last one
+	Total int
+	// Set by the caller to stop fetching, for instance once items are older than needed
+	Done bool
+}
+
+// Fetches pages of at most pageSize items until the last page, or until limit items were fetched (0 for no limit).
+// fetch is called with the number of items to request, and the offset of the first one
+func paginate[T any](pageSize, limit int, fetch func(count, offset int) (*page[T], *retry.Error)) ([]T, *retry.Error) {
+	items := []T{}
+
+	for limit <= 0 || len(items) < limit {
+		count := pageSize
+		if limit > 0 {
+			count = min(pageSize, limit-len(items))
+		}
+
+		current, err := fetch(count, len(items))
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, current.Items...)
+
+		if current.Done || len(current.Items) == 0 {
+			break
+		}
+
+		if current.Total > 0 && len(items) >= current.Total {
+			break
+		}
+
+		if current.Total == 0 && len(current.Items) < count {
+			break
+		}
+	}
+
+	return items, nil
+}
@@ -36,6 +36,7 @@ type plsExtension struct {
 
 type playlistExtension struct {
 	AdditionalMetadata additionalMeta `json:"additional_metadata"`
+	LastModifiedAt     time.Time      `json:"last_modified_at"`
 }
 
 type additionalMeta struct {
@@ -72,6 +73,19 @@ type Artist struct {
 	MBID             string `json:"artist_mbid"`
 }
 
+type lbzRadioResponse struct {
+	Payload radioPayload `json:"payload"`
+}
+
+type radioPayload struct {
+	Feedback []string  `json:"feedback"`
+	Jspf     radioJspf `json:"jspf"`
+}
+
+type radioJspf struct {
+	Playlist LbzPlaylist `json:"playlist"`
+}
+
 type LbzRecommendations struct {
 	Code    int                   `json:"code"`
 	Error   string                `json:"error"`
@@ -85,7 +99,9 @@ type RecommendationPayload struct {
 }
 
 type RecordingMBID struct {
-	RecordingMBID string `json:"recording_mbid"`
+	RecordingMBID    string     `json:"recording_mbid"`
+	Score            float64    `json:"score"`
+	LatestListenedAt *time.Time `json:"latest_listened_at"`
 }
 
 type lbzMetadataLookup struct {
@@ -117,3 +133,100 @@ type recLookup struct {
 	RecordingMbids []string `json:"recording_mbids"`
 	Inc            string   `json:"inc"`
 }
+
+type lbzFeedbackResponse struct {
+	Count      int           `json:"count"`
+	Offset     int           `json:"offset"`
+	TotalCount int           `json:"total_count"`
+	Feedback   []lbzFeedback `json:"feedback"`
+}
+
+type lbzFeedback struct {
+	Created       int64  `json:"created"`
+	RecordingMBID string `json:"recording_mbid"`
+	RecordingMSID string `json:"recording_msid"`
+	Score         int    `json:"score"`
+}
+
+type feedbackSubmission struct {
+	RecordingMBID string `json:"recording_mbid"`
+	Score         int    `json:"score"`
+}
+
+type lbzListensResponse struct {
+	Payload listensPayload `json:"payload"`
+}
+
+type listensPayload struct {
+	Count   int         `json:"count"`
+	Listens []lbzListen `json:"listens"`
+}
+
+type lbzListen struct {
+	ListenedAt    int64          `json:"listened_at"`
+	RecordingMSID string         `json:"recording_msid"`
+	TrackMetadata listenMetadata `json:"track_metadata"`
+}
+
+type listenMetadata struct {
+	AdditionalInfo listenInfo     `json:"additional_info"`
+	MbidMapping    *listenMapping `json:"mbid_mapping"`
+}
+
+type listenInfo struct {
+	RecordingMBID string `json:"recording_mbid"`
+}
+
+type listenMapping struct {
+	RecordingMBID string `json:"recording_mbid"`
+}
+
+// The recording MBID of a listen, preferring the one mapped by ListenBrainz over the one submitted by the scrobbler
+func (l *lbzListen) recordingMBID() string {
+	if l.TrackMetadata.MbidMapping != nil && l.TrackMetadata.MbidMapping.RecordingMBID != "" {
+		return l.TrackMetadata.MbidMapping.RecordingMBID
+	}
+
+	return l.TrackMetadata.AdditionalInfo.RecordingMBID
+}
+
+type LbzTopRecordings struct {
+	Payload TopRecordingsPayload `json:"payload"`
+}
+
+type TopRecordingsPayload struct {
+	Count               int            `json:"count"`
+	Range               string         `json:"range"`
+	LastUpdated         int64          `json:"last_updated"`
+	TotalRecordingCount int            `json:"total_recording_count"`
+	Recordings          []TopRecording `json:"recordings"`
+}
+
+type TopRecording struct {
+	ArtistName    string   `json:"artist_name"`
+	Artists       []Artist `json:"artists"`
+	ListenCount   int      `json:"listen_count"`
+	RecordingMBID string   `json:"recording_mbid"`
+	ReleaseMBID   string   `json:"release_mbid"`
+	ReleaseName   string   `json:"release_name"`
+	TrackName     string   `json:"track_name"`
+}
+
+type lbzFreshReleasesResponse struct {
+	Payload freshReleasesPayload `json:"payload"`
+}
+
+type freshReleasesPayload struct {
+	Releases []FreshRelease `json:"releases"`
+	UserId   string         `json:"user_id"`
+}
+
+type FreshRelease struct {
+	ArtistCreditName string   `json:"artist_credit_name"`
+	ArtistMBIDs      []string `json:"artist_mbids"`
+	// The release date, as YYYY-MM-DD
+	ReleaseDate      string `json:"release_date"`
+	ReleaseGroupMBID string `json:"release_group_mbid"`
+	ReleaseMBID      string `json:"release_mbid"`
+	ReleaseName      string `json:"release_name"`
+}
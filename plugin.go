@@ -14,14 +14,28 @@ import (
 )
 
 const (
-	fetch     = "fetch"
-	dailyCron = "daily-cron"
+	fetch          = "fetch"
+	dailyCron      = "daily-cron"
+	rematchMissing = "rematch-missing"
 )
 
 type brainzPlaylistPlugin struct{}
 
 func (b *brainzPlaylistPlugin) OnCallback(req scheduler.SchedulerCallbackRequest) error {
-	return dispatcher.InitialFetch()
+	if dispatcher.IsRetry(req.Payload) {
+		return dispatcher.EnqueueRetry(req.Payload)
+	}
+
+	switch req.Payload {
+	case fetch:
+		return dispatcher.InitialFetch()
+	case dailyCron:
+		return dispatcher.DailyFetch()
+	case rematchMissing:
+		return dispatcher.CheckMissing()
+	default:
+		return dispatcher.ScheduledFetch(req.Payload)
+	}
 }
 
 func (b *brainzPlaylistPlugin) OnTaskExecute(req taskworker.TaskExecuteRequest) (string, error) {
@@ -39,6 +53,10 @@ func (b *brainzPlaylistPlugin) OnTaskExecute(req taskworker.TaskExecuteRequest)
 
 	if result != nil {
 		if result.Retryable {
+			if result.RetryAfter > 0 && dispatcher.ScheduleRetry(req.TaskID, job, result.RetryAfter) {
+				return fmt.Sprintf("retry scheduled in %s: %v", result.RetryAfter, result.Error), nil
+			}
+
 			return "", result.Error
 		}
 
@@ -73,7 +91,7 @@ func (b *brainzPlaylistPlugin) OnInit() error {
 
 	dispatcher.ClearQueue()
 
-	_, err = dispatcher.GetConfig()
+	users, err := dispatcher.GetConfig()
 	if err != nil {
 		return err
 	}
@@ -83,6 +101,20 @@ func (b *brainzPlaylistPlugin) OnInit() error {
 		return fmt.Errorf("failed to schedule playlist sync. Is your schedule a valid cron expression? %v", err)
 	}
 
+	err = dispatcher.SchedulePlaylists(users, schedInt)
+	if err != nil {
+		return err
+	}
+
+	// There is no event for completed library scans, so they are checked for hourly
+	rematch, _ := pdk.GetConfig("rematchMissing")
+	if rematch == "true" {
+		_, err = host.SchedulerScheduleRecurring("0~59 * * * *", rematchMissing, rematchMissing)
+		if err != nil {
+			return fmt.Errorf("failed to schedule rematching missing tracks: %v", err)
+		}
+	}
+
 	checkOnStartup, ok := pdk.GetConfig("checkOnStartup")
 
 	if !ok || checkOnStartup != "false" {
@@ -86,12 +86,25 @@ var _ = Describe("Plugin", func() {
 			host.SchedulerMock.AssertCalled(GinkgoT(), "ScheduleRecurring", "0~59 7 * * *", "daily-cron", "daily-cron")
 		})
 
+		It("should error if a playlist schedule cannot be registered", func() {
+			pdk.PDKMock.On("GetConfig", "schedule").Return("7", true)
+			host.TaskMock.On("CreateQueue", "job-queue", queueConfig).Return(nil)
+			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(0), nil)
+			pdk.PDKMock.On("GetConfig", "users").Return(`[{"username":"user","lbzUsername":"lbz","sources":[{"sourcePatch":"weekly-jams","playlistName":"Weekly","schedule":"weekly:monday"}]}]`, true)
+			host.SchedulerMock.On("ScheduleRecurring", "0~59 7 * * *", "daily-cron", "daily-cron").Return("1234", nil)
+			host.SchedulerMock.On("ScheduleRecurring", "0~59 7 * * 1", `{"username":"user","playlist":"Weekly"}`, "schedule/user/Weekly").Return("", errors.New("error"))
+			err := b.OnInit()
+			Expect(err).To(MatchError("failed to schedule sync of playlist Weekly for user user. Is `0~59 7 * * 1` a valid cron expression? error"))
+			host.SchedulerMock.AssertCalled(GinkgoT(), "ScheduleRecurring", "0~59 7 * * 1", `{"username":"user","playlist":"Weekly"}`, "schedule/user/Weekly")
+		})
+
 		It("should succeed if check on startup is false", func() {
 			pdk.PDKMock.On("GetConfig", "schedule").Return("7", true)
 			host.TaskMock.On("CreateQueue", "job-queue", queueConfig).Return(nil)
 			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(0), errors.New("Error"))
 			pdk.PDKMock.On("GetConfig", "users").Return("[]", true)
 			host.SchedulerMock.On("ScheduleRecurring", "0~59 7 * * *", "daily-cron", "daily-cron").Return("1234", nil)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
 			pdk.PDKMock.On("GetConfig", "checkOnStartup").Return("false", true)
 			err := b.OnInit()
 			Expect(err).To(BeNil())
@@ -109,6 +122,7 @@ var _ = Describe("Plugin", func() {
 			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(0), errors.New("Error"))
 			pdk.PDKMock.On("GetConfig", "users").Return("[]", true)
 			host.SchedulerMock.On("ScheduleRecurring", "0~59 7 * * *", "daily-cron", "daily-cron").Return("1234", nil)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("", false)
 			pdk.PDKMock.On("GetConfig", "checkOnStartup").Return("true", true)
 			host.SchedulerMock.On("ScheduleOneTime", int32(1), "fetch", "fetch").Return("5678", nil)
 			err := b.OnInit()
@@ -119,6 +133,21 @@ var _ = Describe("Plugin", func() {
 			host.SchedulerMock.AssertCalled(GinkgoT(), "ScheduleRecurring", "0~59 7 * * *", "daily-cron", "daily-cron")
 			pdk.PDKMock.AssertCalled(GinkgoT(), "GetConfig", "checkOnStartup")
 			host.SchedulerMock.AssertCalled(GinkgoT(), "ScheduleOneTime", int32(1), "fetch", "fetch")
+			host.SchedulerMock.AssertNotCalled(GinkgoT(), "ScheduleRecurring", "0~59 * * * *", "rematch-missing", "rematch-missing")
+		})
+
+		It("should check for library scans hourly if rematching missing tracks", func() {
+			pdk.PDKMock.On("GetConfig", "schedule").Return("7", true)
+			host.TaskMock.On("CreateQueue", "job-queue", queueConfig).Return(nil)
+			host.TaskMock.On("ClearQueue", "job-queue").Return(int64(0), nil)
+			pdk.PDKMock.On("GetConfig", "users").Return("[]", true)
+			host.SchedulerMock.On("ScheduleRecurring", "0~59 7 * * *", "daily-cron", "daily-cron").Return("1234", nil)
+			pdk.PDKMock.On("GetConfig", "rematchMissing").Return("true", true)
+			host.SchedulerMock.On("ScheduleRecurring", "0~59 * * * *", "rematch-missing", "rematch-missing").Return("5678", nil)
+			pdk.PDKMock.On("GetConfig", "checkOnStartup").Return("false", true)
+			err := b.OnInit()
+			Expect(err).To(BeNil())
+			host.SchedulerMock.AssertCalled(GinkgoT(), "ScheduleRecurring", "0~59 * * * *", "rematch-missing", "rematch-missing")
 		})
 	})
 })
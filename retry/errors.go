@@ -2,11 +2,50 @@ package retry
 
 import (
 	"errors"
+	"time"
 )
 
+// The category of an error, used to decide whether (and when) a job should be retried
+type Kind int
+
+const (
+	// An error which does not fall into any other category
+	Unknown Kind = iota
+	// A network or server (5xx) error, which is expected to resolve itself
+	Transient
+	// A rate limit was hit
+	RateLimited
+	// The token was missing or rejected
+	Unauthorized
+	// The requested resource does not exist
+	NotFound
+	// The response could not be parsed
+	Malformed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Transient:
+		return "transient"
+	case RateLimited:
+		return "rate-limited"
+	case Unauthorized:
+		return "unauthorized"
+	case NotFound:
+		return "not-found"
+	case Malformed:
+		return "malformed"
+	default:
+		return "unknown"
+	}
+}
+
 type Error struct {
 	Error     error
 	Retryable bool
+	Kind      Kind
+	// How long the server asked to wait before retrying. Zero if there was no hint
+	RetryAfter time.Duration
 }
 
 func (e *Error) Result() (string, error) {
@@ -24,3 +63,23 @@ func FatalError(msg string) *Error {
 func TempError(err error) *Error {
 	return &Error{Error: err, Retryable: true}
 }
+
+func TransientError(err error, retryAfter time.Duration) *Error {
+	return &Error{Error: err, Retryable: true, Kind: Transient, RetryAfter: retryAfter}
+}
+
+func RateLimitError(err error, retryAfter time.Duration) *Error {
+	return &Error{Error: err, Retryable: true, Kind: RateLimited, RetryAfter: retryAfter}
+}
+
+func AuthError(err error) *Error {
+	return &Error{Error: err, Retryable: false, Kind: Unauthorized}
+}
+
+func NotFoundError(err error) *Error {
+	return &Error{Error: err, Retryable: false, Kind: NotFound}
+}
+
+func MalformedError(err error) *Error {
+	return &Error{Error: err, Retryable: false, Kind: Malformed}
+}
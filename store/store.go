@@ -0,0 +1,48 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/navidrome/navidrome/plugins/pdk/go/host"
+)
+
+// Fetches key from the plugin key-value store, deserializing it into value.
+// The boolean return is false if the key does not exist
+func Get(key string, value any) (bool, error) {
+	data, ok, err := host.KVStoreGet(key)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Serializes value as JSON and stores it under key
+func Set(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return host.KVStoreSet(key, data)
+}
+
+// Removes key from the plugin key-value store
+func Delete(key string) error {
+	return host.KVStoreDelete(key)
+}
+
+// Serializes value as JSON and stores it under key, expiring after ttl
+func SetWithTTL(key string, value any, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return host.KVStoreSetWithTTL(key, data, int64(ttl.Seconds()))
+}
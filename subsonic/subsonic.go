@@ -5,19 +5,32 @@ import (
 	"fmt"
 	"listenbrainz-daily-playlist/retry"
 	"net/url"
+	"strconv"
 
 	"github.com/navidrome/navidrome/plugins/pdk/go/host"
 	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
 )
 
+// The number of songs requested per search3 call when walking the whole library
+const libraryPageSize = 500
+
+// The number of songs searched when looking for every copy of a recording
+const recordingSearchSize = 20
+
 type SubsonicHandler struct {
 	artistMbidToId map[string]string
+	artistIdToName map[string]string
+	artistSongs    map[string][]Child
+	artistsLoaded  bool
 	fallbackCount  int
 }
 
 func NewSubsonicHandler(fallbackCount int) *SubsonicHandler {
 	return &SubsonicHandler{
 		artistMbidToId: make(map[string]string),
+		artistIdToName: make(map[string]string),
+		artistSongs:    make(map[string][]Child),
 		fallbackCount:  fallbackCount,
 	}
 }
@@ -73,6 +86,30 @@ func FindExistingPlaylist(resp *JsonWrapper, playlistName string) *Playlist {
 	return nil
 }
 
+// Returns the songs currently in the playlist with a given name, or nil if the playlist does not exist
+func GetPlaylistEntries(subsonicUser, playlistName string) ([]Child, *retry.Error) {
+	subsonicResp, err := Call("getPlaylists", subsonicUser, &url.Values{"username": []string{subsonicUser}})
+	if err != nil {
+		return nil, err
+	}
+
+	existingPlaylist := FindExistingPlaylist(subsonicResp, playlistName)
+	if existingPlaylist == nil {
+		return nil, nil
+	}
+
+	subsonicResp, err = Call("getPlaylist", subsonicUser, &url.Values{"id": []string{existingPlaylist.Id}})
+	if err != nil {
+		return nil, err
+	}
+
+	if subsonicResp.Subsonic.Playlist == nil {
+		return []Child{}, nil
+	}
+
+	return subsonicResp.Subsonic.Playlist.Entry, nil
+}
+
 func UpdatePlaylist(subsonicUser, playlistName, comment string, songIds []string) *retry.Error {
 	subsonicResp, err := Call("getPlaylists", subsonicUser, &url.Values{"username": []string{subsonicUser}})
 	if err != nil {
@@ -107,3 +144,256 @@ func UpdatePlaylist(subsonicUser, playlistName, comment string, songIds []string
 
 	return nil
 }
+
+func RenamePlaylist(subsonicUser, playlistId, name string) *retry.Error {
+	_, err := Call("updatePlaylist", subsonicUser, &url.Values{"playlistId": []string{playlistId}, "name": []string{name}})
+	return err
+}
+
+func DeletePlaylist(subsonicUser, playlistId string) *retry.Error {
+	_, err := Call("deletePlaylist", subsonicUser, &url.Values{"id": []string{playlistId}})
+	return err
+}
+
+// Returns every song in the library visible to the user, using an empty search3 query one page at a time
+func LibrarySongs(subsonicUser string) ([]Child, *retry.Error) {
+	return librarySongs(subsonicUser, libraryPageSize)
+}
+
+func librarySongs(subsonicUser string, pageSize int) ([]Child, *retry.Error) {
+	songs := []Child{}
+
+	for {
+		params := url.Values{
+			"query":       []string{""},
+			"artistCount": []string{"0"},
+			"albumCount":  []string{"0"},
+			"songCount":   []string{strconv.Itoa(pageSize)},
+			"songOffset":  []string{strconv.Itoa(len(songs))},
+		}
+
+		resp, err := Call("search3", subsonicUser, &params)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Subsonic.SearchResult3 == nil {
+			return songs, nil
+		}
+
+		page := resp.Subsonic.SearchResult3.Song
+		songs = append(songs, page...)
+
+		if len(page) < pageSize {
+			return songs, nil
+		}
+	}
+}
+
+// Finds every copy of a recording in the library, searching by title and keeping the songs with the recording MBID
+func FindRecording(subsonicUser, title, mbid string) ([]Child, *retry.Error) {
+	params := url.Values{
+		"query":       []string{title},
+		"artistCount": []string{"0"},
+		"albumCount":  []string{"0"},
+		"songCount":   []string{strconv.Itoa(recordingSearchSize)},
+	}
+
+	resp, err := Call("search3", subsonicUser, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := []Child{}
+	if resp.Subsonic.SearchResult3 == nil {
+		return songs, nil
+	}
+
+	for _, song := range resp.Subsonic.SearchResult3.Song {
+		if song.MusicBrainzId == mbid {
+			songs = append(songs, song)
+		}
+	}
+
+	return songs, nil
+}
+
+// Finds an album in the library by its release MBID, searching by name. The album is returned with its songs, in order.
+// Returns nil if there is no such album
+func FindAlbum(subsonicUser, name, mbid string) (*AlbumID3, *retry.Error) {
+	params := url.Values{
+		"query":       []string{name},
+		"artistCount": []string{"0"},
+		"albumCount":  []string{"20"},
+		"songCount":   []string{"0"},
+	}
+
+	resp, err := Call("search3", subsonicUser, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Subsonic.SearchResult3 == nil {
+		return nil, nil
+	}
+
+	for _, album := range resp.Subsonic.SearchResult3.Album {
+		if album.MusicBrainzId != mbid {
+			continue
+		}
+
+		resp, err = Call("getAlbum", subsonicUser, &url.Values{"id": []string{album.Id}})
+		if err != nil {
+			return nil, err
+		}
+
+		return resp.Subsonic.Album, nil
+	}
+
+	return nil, nil
+}
+
+// Fetches a single song of the library by ID
+func GetSong(subsonicUser, id string) (*Child, *retry.Error) {
+	resp, err := Call("getSong", subsonicUser, &url.Values{"id": []string{id}})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Subsonic.Song == nil {
+		return nil, &retry.Error{Error: fmt.Errorf("song %s not found", id), Retryable: false}
+	}
+
+	return resp.Subsonic.Song, nil
+}
+
+func (c *Child) ToTrack() *types.Track {
+	track := &types.Track{
+		ID:             c.Id,
+		Title:          c.Title,
+		Album:          c.Album,
+		AlbumID:        c.AlbumId,
+		Artist:         c.Artist,
+		Duration:       float64(c.Duration),
+		BitRate:        c.BitRate,
+		Suffix:         c.Suffix,
+		Rating:         c.UserRating,
+		MbzRecordingID: c.MusicBrainzId,
+	}
+
+	if c.Played != nil {
+		played := c.Played.Unix()
+		track.PlayDate = &played
+	}
+
+	for _, artist := range c.Artists {
+		track.Participants = append(track.Participants, types.ArtistRef{
+			ID:   artist.Id,
+			Name: artist.Name,
+			MBID: artist.MusicBrainzId,
+			Role: "artist",
+		})
+	}
+
+	return track
+}
+
+func (s *SubsonicHandler) loadArtists(subsonicUser string) *retry.Error {
+	if s.artistsLoaded {
+		return nil
+	}
+
+	resp, err := Call("getArtists", subsonicUser, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.Subsonic.Artists != nil {
+		for _, index := range resp.Subsonic.Artists.Index {
+			for _, artist := range index.Artists {
+				s.artistIdToName[artist.Id] = artist.Name
+				if artist.MusicBrainzId != "" {
+					s.artistMbidToId[artist.MusicBrainzId] = artist.Id
+				}
+			}
+		}
+	}
+
+	s.artistsLoaded = true
+	return nil
+}
+
+func (s *SubsonicHandler) artistTracks(subsonicUser, artistId string) ([]Child, *retry.Error) {
+	songs, ok := s.artistSongs[artistId]
+	if ok {
+		return songs, nil
+	}
+
+	params := url.Values{
+		"query":       []string{s.artistIdToName[artistId]},
+		"artistCount": []string{"0"},
+		"albumCount":  []string{"0"},
+		"songCount":   []string{strconv.Itoa(s.fallbackCount)},
+	}
+
+	resp, err := Call("search3", subsonicUser, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	songs = []Child{}
+
+	if resp.Subsonic.SearchResult3 != nil {
+	outer:
+		for _, song := range resp.Subsonic.SearchResult3.Song {
+			if song.ArtistId == artistId {
+				songs = append(songs, song)
+				continue
+			}
+
+			for _, artist := range song.Artists {
+				if artist.Id == artistId {
+					songs = append(songs, song)
+					continue outer
+				}
+			}
+		}
+	}
+
+	s.artistSongs[artistId] = songs
+	return songs, nil
+}
+
+// Finds a substitute track for a recording that could not be matched.
+// The first artist (by MBID) with a track in the library, searching at most fallbackCount tracks,
+// that is not in exclude is returned. Returns nil if fallback is disabled, or no track was found
+func (s *SubsonicHandler) FindFallback(subsonicUser string, artistMbids []string, exclude map[string]bool) (*types.Track, *retry.Error) {
+	if s.fallbackCount <= 0 {
+		return nil, nil
+	}
+
+	err := s.loadArtists(subsonicUser)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mbid := range artistMbids {
+		artistId, ok := s.artistMbidToId[mbid]
+		if !ok {
+			continue
+		}
+
+		songs, err := s.artistTracks(subsonicUser, artistId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, song := range songs {
+			if !exclude[song.Id] {
+				return song.ToTrack(), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
@@ -8,9 +8,11 @@ import (
 	"listenbrainz-daily-playlist/retry"
 	"listenbrainz-daily-playlist/testdata"
 	"net/url"
+	"time"
 
 	"github.com/navidrome/navidrome/plugins/pdk/go/host"
 	"github.com/navidrome/navidrome/plugins/pdk/go/pdk"
+	"github.com/navidrome/navidrome/plugins/pdk/go/types"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
@@ -194,4 +196,204 @@ var _ = Describe("", func() {
 			validateCalls()
 		})
 	})
+	Describe("GetPlaylistEntries", func() {
+		It("returns nil when the playlist does not exist", func() {
+			mockSubsonicResponse("getPlaylists", &url.Values{"username": []string{user}}, "noPlaylists")
+
+			entries, err := GetPlaylistEntries(user, "Generated Daily Jams")
+			Expect(entries).To(BeNil())
+			Expect(err).To(BeNil())
+			validateCalls()
+		})
+
+		It("returns the songs of an existing playlist", func() {
+			mockSubsonicResponse("getPlaylists", &url.Values{"username": []string{user}}, "existingPlaylists")
+			mockSubsonicResponse("getPlaylist", &url.Values{"id": []string{"C8hOrsjiVnnHZTXqxLs57t"}}, "createPlaylist")
+
+			entries, err := GetPlaylistEntries(user, "Generated Daily Jams")
+			Expect(err).To(BeNil())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Id).To(Equal("cd020be4e71f3f9a1856ebc89741f4d9"))
+			Expect(entries[0].Title).To(Equal("world.execute(me);"))
+			validateCalls()
+		})
+	})
+
+	Describe("LibrarySongs", func() {
+		page := func(offset int) *url.Values {
+			return &url.Values{
+				"query":       []string{""},
+				"artistCount": []string{"0"},
+				"albumCount":  []string{"0"},
+				"songCount":   []string{"3"},
+				"songOffset":  []string{fmt.Sprint(offset)},
+			}
+		}
+
+		It("fetches pages until one is not full", func() {
+			mockSubsonicResponse("search3", page(0), "search3")
+			mockSubsonicResponse("search3", page(3), "search3.empty")
+
+			songs, err := librarySongs(user, 3)
+			Expect(err).To(BeNil())
+			Expect(songs).To(HaveLen(3))
+			Expect(songs[2].UserRating).To(Equal(int32(4)))
+			validateCalls()
+		})
+
+		It("returns the error of a failed page", func() {
+			mockSubsonicResponse("search3", page(0), "search3")
+			mockSubsonicResponse("search3", page(3), "error")
+
+			songs, err := librarySongs(user, 3)
+			Expect(songs).To(BeNil())
+			Expect(err).To(Equal(retry.FatalError("subsonic status is not ok: (40) Wrong username or password")))
+		})
+	})
+
+	Describe("FindAlbum", func() {
+		search := &url.Values{
+			"query":       []string{"Miracle Milk"},
+			"artistCount": []string{"0"},
+			"albumCount":  []string{"20"},
+			"songCount":   []string{"0"},
+		}
+
+		It("returns the album with a matching release MBID, with its songs", func() {
+			mockSubsonicResponse("search3", search, "search3.albums")
+			mockSubsonicResponse("getAlbum", &url.Values{"id": []string{"04A1833aXINiHFfq8i1eie"}}, "getAlbum")
+
+			album, err := FindAlbum(user, "Miracle Milk", "2ff07e32-4f73-4b43-bb54-6f1b5d5e1f0a")
+			Expect(err).To(BeNil())
+			Expect(album.Name).To(Equal("Miracle Milk"))
+			Expect(album.Song).To(HaveLen(2))
+			Expect(album.Song[0].Title).To(Equal("world.execute(me);"))
+			validateCalls()
+		})
+
+		It("returns nothing if no album has the release MBID", func() {
+			mockSubsonicResponse("search3", search, "search3.albums")
+
+			album, err := FindAlbum(user, "Miracle Milk", "00000000-0000-0000-0000-000000000000")
+			Expect(album).To(BeNil())
+			Expect(err).To(BeNil())
+			validateCalls()
+		})
+	})
+
+	Describe("FindRecording", func() {
+		It("returns the songs of the recording", func() {
+			mockSubsonicResponse("search3", &url.Values{
+				"query":       []string{"world.execute(me);"},
+				"artistCount": []string{"0"},
+				"albumCount":  []string{"0"},
+				"songCount":   []string{"20"},
+			}, "search3.duplicates")
+
+			songs, err := FindRecording(user, "world.execute(me);", "9980309d-3480-4e7e-89ce-fce971a452be")
+			Expect(err).To(BeNil())
+			Expect(songs).To(HaveLen(2))
+			Expect(songs[0].Album).To(Equal("Miracle Milk"))
+			Expect(songs[1].Album).To(Equal("Mili Best"))
+			validateCalls()
+		})
+	})
+
+	Describe("GetSong", func() {
+		It("returns the song", func() {
+			mockSubsonicResponse("getSong", &url.Values{"id": []string{"6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98"}}, "getSong")
+
+			song, err := GetSong(user, "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98")
+			Expect(err).To(BeNil())
+			Expect(song.Title).To(Equal("Rubber Human"))
+			Expect(song.UserRating).To(Equal(int32(4)))
+			validateCalls()
+		})
+
+		It("errors if the song does not exist", func() {
+			mockSubsonicResponse("getSong", &url.Values{"id": []string{"missing"}}, "error")
+
+			song, err := GetSong(user, "missing")
+			Expect(song).To(BeNil())
+			Expect(err.Retryable).To(BeFalse())
+			validateCalls()
+		})
+	})
+
+	Describe("FindFallback", func() {
+		const (
+			MILI_MBID = "d2a92ee2-27ce-4e71-bfc5-12e34fe8ef56"
+			ACE_MBID  = "16563fb9-c2b5-4ab7-b5b1-7b6592f862a1"
+		)
+
+		search := func(query string) *url.Values {
+			return &url.Values{
+				"query":       []string{query},
+				"artistCount": []string{"0"},
+				"albumCount":  []string{"0"},
+				"songCount":   []string{"15"},
+			}
+		}
+
+		It("does nothing if fallback is disabled", func() {
+			handler := NewSubsonicHandler(0)
+			track, err := handler.FindFallback(user, []string{MILI_MBID}, nil)
+			Expect(track).To(BeNil())
+			Expect(err).To(BeNil())
+			Expect(host.SubsonicAPIMock.Calls).To(BeEmpty())
+		})
+
+		It("errors if artists cannot be fetched", func() {
+			mockSubsonicResponse("getArtists", nil, "error")
+
+			handler := NewSubsonicHandler(15)
+			track, err := handler.FindFallback(user, []string{MILI_MBID}, nil)
+			Expect(track).To(BeNil())
+			Expect(err).To(Equal(retry.FatalError("subsonic status is not ok: (40) Wrong username or password")))
+			validateCalls()
+		})
+
+		It("returns nothing for an artist not in the library", func() {
+			mockSubsonicResponse("getArtists", nil, "getArtists")
+
+			handler := NewSubsonicHandler(15)
+			track, err := handler.FindFallback(user, []string{"00000000-0000-0000-0000-000000000000"}, nil)
+			Expect(track).To(BeNil())
+			Expect(err).To(BeNil())
+			validateCalls()
+		})
+
+		It("returns the first track by the artist that is not excluded, caching lookups", func() {
+			mockSubsonicResponse("getArtists", nil, "getArtists")
+			mockSubsonicResponse("search3", search("Mili"), "search3")
+			mockSubsonicResponse("search3", search("ACE"), "search3")
+
+			handler := NewSubsonicHandler(15)
+			track, err := handler.FindFallback(user, []string{MILI_MBID}, map[string]bool{})
+			Expect(err).To(BeNil())
+			Expect(track.ID).To(Equal("cd020be4e71f3f9a1856ebc89741f4d9"))
+
+			played := time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC).Unix()
+
+			track, err = handler.FindFallback(user, []string{ACE_MBID, MILI_MBID}, map[string]bool{"cd020be4e71f3f9a1856ebc89741f4d9": true})
+			Expect(err).To(BeNil())
+			Expect(track).To(Equal(&types.Track{
+				ID:             "6b3c9c1a8f6e4b1d9a2e7f5c3d1b0a98",
+				Title:          "Rubber Human",
+				Album:          "Miracle Milk",
+				AlbumID:        "04A1833aXINiHFfq8i1eie",
+				Artist:         "Mili",
+				Duration:       183,
+				BitRate:        291,
+				Suffix:         "mp3",
+				Rating:         4,
+				PlayDate:       &played,
+				MbzRecordingID: "3f1e0c55-4f0c-4f6a-9b3b-2b8e3f0b6c11",
+				Participants:   []types.ArtistRef{{ID: "2fURvRfCF5WaU1262xTQLp", Name: "Mili", Role: "artist"}},
+			}))
+
+			// getArtists once, and each artist searched once
+			Expect(host.SubsonicAPIMock.Calls).To(HaveLen(3))
+		})
+	})
 })
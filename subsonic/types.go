@@ -16,17 +16,69 @@ type Playlist struct {
 	Comment string    `xml:"comment,attr,omitempty"        json:"comment,omitempty"`
 	Public  bool      `xml:"public,attr"                   json:"public,omitempty"`
 	Changed time.Time `xml:"changed,attr"                  json:"changed"`
+	Entry   []Child   `xml:"entry,omitempty"               json:"entry,omitempty"`
 }
 
 type Playlists struct {
 	Playlist []Playlist `xml:"playlist"                           json:"playlist,omitempty"`
 }
 
+type ArtistID3 struct {
+	Id            string `xml:"id,attr"                       json:"id"`
+	Name          string `xml:"name,attr"                     json:"name"`
+	MusicBrainzId string `xml:"musicBrainzId,attr,omitempty"  json:"musicBrainzId,omitempty"`
+}
+
+type IndexID3 struct {
+	Name    string      `xml:"name,attr"                     json:"name"`
+	Artists []ArtistID3 `xml:"artist"                        json:"artist"`
+}
+
+type Artists struct {
+	Index []IndexID3 `xml:"index"                              json:"index,omitempty"`
+}
+
+type Child struct {
+	Id            string      `xml:"id,attr"                       json:"id"`
+	Title         string      `xml:"title,attr"                    json:"title"`
+	Album         string      `xml:"album,attr,omitempty"          json:"album,omitempty"`
+	AlbumId       string      `xml:"albumId,attr,omitempty"        json:"albumId,omitempty"`
+	Artist        string      `xml:"artist,attr,omitempty"         json:"artist,omitempty"`
+	ArtistId      string      `xml:"artistId,attr,omitempty"       json:"artistId,omitempty"`
+	Duration      int32       `xml:"duration,attr,omitempty"       json:"duration,omitempty"`
+	BitRate       int32       `xml:"bitRate,attr,omitempty"        json:"bitRate,omitempty"`
+	Suffix        string      `xml:"suffix,attr,omitempty"         json:"suffix,omitempty"`
+	UserRating    int32       `xml:"userRating,attr,omitempty"     json:"userRating,omitempty"`
+	Played        *time.Time  `xml:"played,attr,omitempty"         json:"played,omitempty"`
+	Starred       *time.Time  `xml:"starred,attr,omitempty"        json:"starred,omitempty"`
+	MusicBrainzId string      `xml:"musicBrainzId,attr,omitempty"  json:"musicBrainzId,omitempty"`
+	Artists       []ArtistID3 `xml:"artists"                       json:"artists,omitempty"`
+}
+
+type AlbumID3 struct {
+	Id            string  `xml:"id,attr"                       json:"id"`
+	Name          string  `xml:"name,attr"                     json:"name"`
+	Artist        string  `xml:"artist,attr,omitempty"         json:"artist,omitempty"`
+	ArtistId      string  `xml:"artistId,attr,omitempty"       json:"artistId,omitempty"`
+	SongCount     int32   `xml:"songCount,attr"                json:"songCount"`
+	MusicBrainzId string  `xml:"musicBrainzId,attr,omitempty"  json:"musicBrainzId,omitempty"`
+	Song          []Child `xml:"song,omitempty"                json:"song,omitempty"`
+}
+
+type SearchResult3 struct {
+	Album []AlbumID3 `xml:"album"                               json:"album,omitempty"`
+	Song  []Child    `xml:"song"                                json:"song,omitempty"`
+}
+
 type Subsonic struct {
-	Status    string     `xml:"status,attr"                                   json:"status"`
-	Error     *Error     `xml:"error,omitempty"                               json:"error,omitempty"`
-	Playlists *Playlists `xml:"playlists,omitempty"                           json:"playlists,omitempty"`
-	Playlist  *Playlist  `xml:"playlist,omitempty"                            json:"playlist,omitempty"`
+	Status        string         `xml:"status,attr"                                   json:"status"`
+	Error         *Error         `xml:"error,omitempty"                               json:"error,omitempty"`
+	Playlists     *Playlists     `xml:"playlists,omitempty"                           json:"playlists,omitempty"`
+	Playlist      *Playlist      `xml:"playlist,omitempty"                            json:"playlist,omitempty"`
+	Artists       *Artists       `xml:"artists,omitempty"                             json:"artists,omitempty"`
+	SearchResult3 *SearchResult3 `xml:"searchResult3,omitempty"                       json:"searchResult3,omitempty"`
+	Album         *AlbumID3      `xml:"album,omitempty"                               json:"album,omitempty"`
+	Song          *Child         `xml:"song,omitempty"                                json:"song,omitempty"`
 }
 
 type JsonWrapper struct {